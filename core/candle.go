@@ -0,0 +1,194 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"sync"
+
+	"github.com/quagmt/udecimal"
+)
+
+// K线周期，单位秒
+type CandleInterval int64
+
+const (
+	Interval1s CandleInterval = 1
+	Interval1m CandleInterval = 60
+	Interval5m CandleInterval = 300
+	Interval1h CandleInterval = 3600
+	Interval1d CandleInterval = 86400
+)
+
+var DefaultCandleIntervals = []CandleInterval{Interval1s, Interval1m, Interval5m, Interval1h, Interval1d}
+
+type Candle struct {
+	MarketId    string           `json:"marketId"`
+	Interval    CandleInterval   `json:"interval"`
+	OpenTime    int64            `json:"openTime"`  //周期开始时间(秒)，含
+	CloseTime   int64            `json:"closeTime"` //周期结束时间(秒)，不含
+	Open        udecimal.Decimal `json:"open"`
+	High        udecimal.Decimal `json:"high"`
+	Low         udecimal.Decimal `json:"low"`
+	Close       udecimal.Decimal `json:"close"`
+	Volume      udecimal.Decimal `json:"volume"`      //成交量
+	QuoteVolume udecimal.Decimal `json:"quoteVolume"` //成交额
+	TradeCount  int64            `json:"tradeCount"`
+	Closed      bool             `json:"closed"` //是否已收盘
+}
+
+// K线推送，closed=false 的为进行中的K线
+type CandleSink interface {
+	OnCandle(candle *Candle)
+}
+
+// 订阅成交日志生成K线，按成交时间戳切分周期，重放得到相同结果
+type CandleAggregator struct {
+	mu        sync.Mutex
+	intervals []CandleInterval
+	sink      CandleSink
+	bars      map[string]map[CandleInterval]*Candle //交易对 -> 周期 -> 当前K线
+	touched   []*Candle
+}
+
+func NewCandleAggregator(sink CandleSink, intervals ...CandleInterval) *CandleAggregator {
+	if len(intervals) == 0 {
+		intervals = DefaultCandleIntervals
+	}
+	return &CandleAggregator{
+		intervals: intervals,
+		sink:      sink,
+		bars:      make(map[string]map[CandleInterval]*Candle),
+	}
+}
+
+// 实现 PushLog，只处理成交日志
+func (a *CandleAggregator) Publish(logs []*OrderBookLog) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, log := range logs {
+		if log.Type != protocol.LogTypeMatch {
+			continue
+		}
+		price, err := udecimal.Parse(log.Price)
+		if err != nil {
+			continue
+		}
+		size, _ := udecimal.Parse(log.Size)
+		amount, _ := udecimal.Parse(log.Amount)
+		a.addTrade(log.MarketId, log.Timestamp, price, size, amount)
+	}
+	//每批只推送一次进行中的K线
+	for _, bar := range a.touched {
+		if a.sink != nil {
+			a.sink.OnCandle(copyCandle(bar))
+		}
+	}
+	a.touched = a.touched[:0]
+}
+
+func (a *CandleAggregator) addTrade(marketId string, timestamp int64, price, size, amount udecimal.Decimal) {
+	bars, ok := a.bars[marketId]
+	if !ok {
+		bars = make(map[CandleInterval]*Candle, len(a.intervals))
+		a.bars[marketId] = bars
+	}
+	for _, interval := range a.intervals {
+		openTime := timestamp - timestamp%int64(interval)
+		bar := bars[interval]
+		//时间晚于当前周期，收盘当前K线并开新K线；早于当前周期的乱序成交计入当前K线
+		if bar == nil || openTime > bar.OpenTime {
+			if bar != nil {
+				a.closeBar(bar)
+			}
+			bar = &Candle{
+				MarketId:  marketId,
+				Interval:  interval,
+				OpenTime:  openTime,
+				CloseTime: openTime + int64(interval),
+				Open:      price,
+				High:      price,
+				Low:       price,
+			}
+			bars[interval] = bar
+		}
+		if price.GreaterThan(bar.High) {
+			bar.High = price
+		}
+		if price.LessThan(bar.Low) {
+			bar.Low = price
+		}
+		bar.Close = price
+		bar.Volume = bar.Volume.Add(size)
+		bar.QuoteVolume = bar.QuoteVolume.Add(amount)
+		bar.TradeCount++
+		a.touch(bar)
+	}
+}
+
+func (a *CandleAggregator) closeBar(bar *Candle) {
+	//收盘前的进行中状态已无意义
+	for i, b := range a.touched {
+		if b == bar {
+			a.touched = append(a.touched[:i], a.touched[i+1:]...)
+			break
+		}
+	}
+	bar.Closed = true
+	if a.sink != nil {
+		a.sink.OnCandle(copyCandle(bar))
+	}
+}
+
+func (a *CandleAggregator) touch(bar *Candle) {
+	for _, b := range a.touched {
+		if b == bar {
+			return
+		}
+	}
+	a.touched = append(a.touched, bar)
+}
+
+// 当前进行中的K线副本
+func (a *CandleAggregator) Current(marketId string, interval CandleInterval) *Candle {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	bar := a.bars[marketId][interval]
+	if bar == nil {
+		return nil
+	}
+	return copyCandle(bar)
+}
+
+func copyCandle(bar *Candle) *Candle {
+	temp := &Candle{}
+	*temp = *bar
+	return temp
+}
+
+// 内存K线订阅，测试用
+type MemoryCandles struct {
+	mu      sync.Mutex
+	Candles []*Candle
+}
+
+func NewMemoryCandles() *MemoryCandles {
+	return &MemoryCandles{}
+}
+
+func (mc *MemoryCandles) OnCandle(candle *Candle) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.Candles = append(mc.Candles, candle)
+}
+
+// 已收盘的K线
+func (mc *MemoryCandles) Closed(interval CandleInterval) []*Candle {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	result := make([]*Candle, 0)
+	for _, c := range mc.Candles {
+		if c.Closed && c.Interval == interval {
+			result = append(result, c)
+		}
+	}
+	return result
+}
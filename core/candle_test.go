@@ -0,0 +1,46 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"testing"
+)
+
+// 按成交时间戳切分周期
+func TestCandleAggregator(t *testing.T) {
+	candles := NewMemoryCandles()
+	agg := NewCandleAggregator(candles, Interval1m, Interval5m)
+	b := NewOrderBook("BTC-USDT", NewMultiLog(NewMemoryLog(), agg))
+
+	trade := func(id string, price, size string, ts int64) {
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id + "-s", Side: protocol.Sell, OrderType: protocol.TypeLimit, Price: price, Size: size, UserId: 1, Timestamp: ts,
+		})
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id + "-b", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: price, Size: size, UserId: 2, Timestamp: ts,
+		})
+	}
+	trade("t1", "100", "1", 60)
+	trade("t2", "105", "2", 90)
+	trade("t3", "98", "1", 119)
+	trade("t4", "101", "1", 120)
+
+	closed := candles.Closed(Interval1m)
+	assertInt64(t, 1, int64(len(closed)), "closed 1m bars")
+	bar := closed[0]
+	assertInt64(t, 60, bar.OpenTime, "open time")
+	assertInt64(t, 120, bar.CloseTime, "close time")
+	assertString(t, "100", bar.Open.String(), "open")
+	assertString(t, "105", bar.High.String(), "high")
+	assertString(t, "98", bar.Low.String(), "low")
+	assertString(t, "98", bar.Close.String(), "close")
+	assertString(t, "4", bar.Volume.String(), "volume")
+	assertString(t, "408", bar.QuoteVolume.String(), "quote volume")
+	assertInt64(t, 3, bar.TradeCount, "trade count")
+
+	current := agg.Current("BTC-USDT", Interval1m)
+	assertInt64(t, 120, current.OpenTime, "current open time")
+	assertBool(t, false, current.Closed, "current in progress")
+	five := agg.Current("BTC-USDT", Interval5m)
+	assertInt64(t, 4, five.TradeCount, "5m trade count")
+	assertInt64(t, 0, int64(len(candles.Closed(Interval5m))), "5m still open")
+}
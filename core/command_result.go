@@ -0,0 +1,179 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"context"
+	"errors"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 指令结果 future，由撮合线程完成
+type CommandFuture struct {
+	done   chan struct{}
+	result *protocol.CommandResult
+}
+
+func newCommandFuture() *CommandFuture {
+	return &CommandFuture{done: make(chan struct{})}
+}
+
+func (f *CommandFuture) resolve(result *protocol.CommandResult) {
+	f.result = result
+	close(f.done)
+}
+
+func (f *CommandFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// 等待结果或 ctx 结束
+func (f *CommandFuture) Wait(ctx context.Context) (*protocol.CommandResult, error) {
+	select {
+	case <-f.done:
+		return f.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 指令入队，处理完成后在撮合线程回调 ack，有指令日志时在本批刷盘后回调，ack 不能阻塞
+func (b *OrderBook) EnqueueCommandWithAck(cmd *protocol.Command, ack func(*protocol.CommandResult)) error {
+	if b.shutDown.Load() {
+		return ErrOrderBookShutdown
+	}
+	seq, slot := b.cmdBuffer.NextSeq()
+	if seq == protocol.NullIndex {
+		return errors.New("no slot")
+	}
+	*slot = protocol.InputEvent{Cmd: cmd, Ack: ack}
+	b.cmdBuffer.Commit(seq)
+	return nil
+}
+
+// 下单并返回结果 future
+func (b *OrderBook) PlaceOrderAsync(cmd *protocol.PlaceOrderCommand) (*CommandFuture, error) {
+	if len(cmd.OrderType) == 0 || len(cmd.OrderId) == 0 {
+		return nil, errors.New("invalid order type")
+	}
+	return b.submitAsync(protocol.CmdPlaceOrder, cmd)
+}
+
+// 撤单并返回结果 future
+func (b *OrderBook) CancelOrderAsync(cmd *protocol.CancelOrderCommand) (*CommandFuture, error) {
+	if len(cmd.OrderId) == 0 {
+		return nil, errors.New("invalid order id")
+	}
+	return b.submitAsync(protocol.CmdCancelOrder, cmd)
+}
+
+// 批量撤单并返回结果 future，结果中汇总全部撤销的订单
+func (b *OrderBook) MassCancelAsync(cmd *protocol.MassCancelCommand) (*CommandFuture, error) {
+	return b.submitAsync(protocol.CmdMassCancel, cmd)
+}
+
+// 改单并返回结果 future
+func (b *OrderBook) AmendOrderAsync(cmd *protocol.AmendOrderCommand) (*CommandFuture, error) {
+	if len(cmd.OrderId) == 0 {
+		return nil, errors.New("invalid order id")
+	}
+	return b.submitAsync(protocol.CmdAmendOrder, cmd)
+}
+
+func (b *OrderBook) submitAsync(cmdType protocol.CommandType, payload any) (*CommandFuture, error) {
+	bs, err := b.serializer.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	input := &protocol.Command{
+		MarketId: b.marketId,
+		Type:     cmdType,
+		Payload:  bs,
+	}
+	future := newCommandFuture()
+	if err := b.EnqueueCommandWithAck(input, future.resolve); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+// 根据指令产生的日志汇总处理结果
+type resultCollector struct {
+	result    *protocol.CommandResult
+	filled    udecimal.Decimal
+	resting   udecimal.Decimal
+	cancelled udecimal.Decimal
+	rejected  bool
+	batch     bool //批量指令，不区分订单，只汇总撤单和拒绝
+}
+
+func newResultCollector(cmdSeqId int64) *resultCollector {
+	return &resultCollector{
+		result: &protocol.CommandResult{CmdSeqId: cmdSeqId},
+	}
+}
+
+// 指令结果只汇总指定订单的日志，需在处理指令前调用
+func (b *OrderBook) collectOrder(orderId string) {
+	if b.collector != nil {
+		b.collector.result.OrderId = orderId
+	}
+}
+
+func (c *resultCollector) collect(logs []*OrderBookLog) {
+	for _, log := range logs {
+		if c.batch {
+			c.collectBatch(log)
+			continue
+		}
+		//只汇总本指令订单的日志，STP、订单组等联动产生的其他订单日志不计入
+		if log.OrderId != c.result.OrderId {
+			continue
+		}
+		switch log.Type {
+		case protocol.LogTypeReject:
+			c.rejected = true
+			c.result.RejectReason = log.RejectReason
+		case protocol.LogTypeMatch:
+			c.result.Fills = append(c.result.Fills, protocol.Fill{
+				TradeId:      log.TradeId,
+				Price:        log.Price,
+				Size:         log.Size,
+				Amount:       log.Amount,
+				MakerOrderId: log.MakerOrderId,
+				MakerUserId:  log.MakerUserId,
+			})
+			size, _ := udecimal.Parse(log.Size)
+			c.filled = c.filled.Add(size)
+		case protocol.LogTypeOpen:
+			c.resting, _ = udecimal.Parse(log.LeftSize)
+		case protocol.LogTypeCancel:
+			c.resting = udecimal.Zero
+			size, _ := udecimal.Parse(log.Size)
+			c.cancelled = c.cancelled.Add(size)
+			c.result.CancelledCount++
+		case protocol.LogTypeAmend:
+			c.resting, _ = udecimal.Parse(log.Size)
+		}
+	}
+}
+
+func (c *resultCollector) collectBatch(log *OrderBookLog) {
+	switch log.Type {
+	case protocol.LogTypeReject:
+		c.rejected = true
+		c.result.RejectReason = log.RejectReason
+	case protocol.LogTypeCancel:
+		size, _ := udecimal.Parse(log.Size)
+		c.cancelled = c.cancelled.Add(size)
+		c.result.CancelledCount++
+	}
+}
+
+func (c *resultCollector) finish() *protocol.CommandResult {
+	c.result.Accepted = !c.rejected || len(c.result.Fills) > 0
+	c.result.FilledSize = c.filled.String()
+	c.result.RestingSize = c.resting.String()
+	c.result.CancelledSize = c.cancelled.String()
+	return c.result
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"context"
+	"testing"
+	"time"
+)
+
+// future 返回成交、挂单剩余和拒绝原因
+func TestOrderBook_CommandFuture(t *testing.T) {
+	b, _ := newTestOrderBook()
+	b.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		b.Shutdown(ctx)
+	}()
+	wait := func(f *CommandFuture, err error) *protocol.CommandResult {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		result, err := f.Wait(ctx)
+		if err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+		return result
+	}
+
+	result := wait(b.PlaceOrderAsync(&protocol.PlaceOrderCommand{
+		OrderId: "s1", Side: protocol.Sell, OrderType: protocol.TypeLimit, Price: "100", Size: "2", UserId: 1,
+	}))
+	assertBool(t, true, result.Accepted, "maker accepted")
+	assertString(t, "2", result.RestingSize, "maker resting")
+
+	result = wait(b.PlaceOrderAsync(&protocol.PlaceOrderCommand{
+		OrderId: "b1", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "3", UserId: 2,
+	}))
+	assertBool(t, true, result.Accepted, "taker accepted")
+	assertString(t, "b1", result.OrderId, "taker order id")
+	assertInt64(t, 1, int64(len(result.Fills)), "taker fills")
+	assertString(t, "s1", result.Fills[0].MakerOrderId, "fill maker")
+	assertString(t, "2", result.FilledSize, "filled size")
+	assertString(t, "1", result.RestingSize, "taker resting")
+
+	result = wait(b.CancelOrderAsync(&protocol.CancelOrderCommand{OrderId: "b1", UserId: 3}))
+	assertBool(t, false, result.Accepted, "wrong owner rejected")
+	assertInt64(t, protocol.ReasonNotOrderOwner, int64(result.RejectReason), "reject reason")
+
+	result = wait(b.CancelOrderAsync(&protocol.CancelOrderCommand{OrderId: "b1", UserId: 2}))
+	assertBool(t, true, result.Accepted, "cancel accepted")
+	assertString(t, "1", result.CancelledSize, "cancelled size")
+	assertString(t, "0", result.RestingSize, "nothing resting")
+}
+
+// STP 撤销旧单时先产生挂单的撤单日志，结果仍按指令中的订单汇总
+func TestOrderBook_CommandResultSTP(t *testing.T) {
+	b, _ := newTestOrderBook()
+	placeLimit(t, b, "m1", 1, protocol.Sell, "100", "1")
+	placeLimit(t, b, "m2", 2, protocol.Sell, "100", "1")
+
+	var result *protocol.CommandResult
+	bs, _ := b.serializer.Marshal(&protocol.PlaceOrderCommand{
+		OrderId: "t1", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "2", UserId: 1, STPMode: protocol.STPCancelOldest,
+	})
+	b.OnEvent(&protocol.InputEvent{
+		Cmd: &protocol.Command{MarketId: b.marketId, SeqId: 1, Type: protocol.CmdPlaceOrder, Payload: bs},
+		Ack: func(r *protocol.CommandResult) { result = r },
+	})
+	assertString(t, "t1", result.OrderId, "result order id")
+	assertString(t, "1", result.FilledSize, "filled size")
+	assertString(t, "1", result.RestingSize, "resting size")
+	assertString(t, "0", result.CancelledSize, "maker cancel not counted")
+}
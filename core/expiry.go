@@ -0,0 +1,103 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"container/heap"
+)
+
+const daySeconds = 86400
+
+type expiryEntry struct {
+	expireAt int64
+	orderId  string
+}
+
+// 到期索引，按到期时间、订单ID排序，保证恢复后顺序一致
+// 订单成交或撤单时不删除条目，到期时再核对订单是否还在
+type expiryIndex []expiryEntry
+
+func (ei expiryIndex) Len() int { return len(ei) }
+func (ei expiryIndex) Less(i, j int) bool {
+	if ei[i].expireAt != ei[j].expireAt {
+		return ei[i].expireAt < ei[j].expireAt
+	}
+	return ei[i].orderId < ei[j].orderId
+}
+func (ei expiryIndex) Swap(i, j int) { ei[i], ei[j] = ei[j], ei[i] }
+func (ei *expiryIndex) Push(x any)   { *ei = append(*ei, x.(expiryEntry)) }
+func (ei *expiryIndex) Pop() any {
+	old := *ei
+	entry := old[len(old)-1]
+	*ei = old[:len(old)-1]
+	return entry
+}
+
+// 根据有效期计算失效时间，返回 0 表示不失效
+func orderExpireAt(bean *protocol.PlaceOrderCommand) (int64, int32) {
+	switch bean.TimeInForce {
+	case "", protocol.TifGTC:
+		return 0, 0
+	case protocol.TifGTD:
+		if bean.ExpireTime <= bean.Timestamp {
+			return 0, protocol.ReasonInvalidExpireTime
+		}
+		return bean.ExpireTime, 0
+	case protocol.TifDAY:
+		//按下单时间所在 UTC 日的结束时间
+		return (bean.Timestamp/daySeconds + 1) * daySeconds, 0
+	}
+	return 0, protocol.ReasonInvalidPayload
+}
+
+// 订单挂单、进入触发簿或作为子单等待激活时登记到期时间
+func (b *OrderBook) trackExpiry(order *protocol.Order) {
+	if order.ExpireAt > 0 {
+		heap.Push(&b.expiries, expiryEntry{expireAt: order.ExpireAt, orderId: order.Id})
+	}
+}
+
+// 从快照恢复后重建到期索引
+func (b *OrderBook) rebuildExpiries() {
+	b.expiries = b.expiries[:0]
+	for _, q := range []*queue{b.bidQueue, b.askQueue} {
+		for _, order := range q.orders {
+			b.trackExpiry(order)
+		}
+	}
+	for _, order := range b.triggers.orders {
+		b.trackExpiry(order)
+	}
+	for _, g := range b.groups.groups {
+		for _, order := range g.pending {
+			b.trackExpiry(order)
+		}
+	}
+}
+
+// 定时指令，撤销 Timestamp 之前(含)到期的订单和心跳超时会话的订单
+func (b *OrderBook) handleTimer(bean *protocol.TimerCommand) {
+	if bean.Timestamp > b.lastTimerTime {
+		b.lastTimerTime = bean.Timestamp
+	}
+	logs := acquireLogSlice()
+	b.expireOrders(bean.Timestamp, logs)
+	b.expireHeartbeats(bean.Timestamp, logs)
+	b.publishLogs(logs)
+}
+
+func (b *OrderBook) expireOrders(now int64, logs *[]*OrderBookLog) {
+	for len(b.expiries) > 0 && b.expiries[0].expireAt <= now {
+		entry := heap.Pop(&b.expiries).(expiryEntry)
+		if order, q := b.findOrder(entry.orderId); order != nil && order.ExpireAt == entry.expireAt {
+			b.cancelResting(order, q, protocol.ReasonExpired, now, logs)
+			continue
+		}
+		if order := b.triggers.get(entry.orderId); order != nil && order.ExpireAt == entry.expireAt {
+			b.cancelTrigger(order, protocol.ReasonExpired, now, logs)
+			continue
+		}
+		if order := b.groups.pendingOrder(entry.orderId); order != nil && order.ExpireAt == entry.expireAt {
+			b.removePendingExit(order, protocol.ReasonExpired, now, logs)
+		}
+	}
+}
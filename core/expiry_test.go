@@ -0,0 +1,61 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"testing"
+)
+
+func placeWithTif(t *testing.T, b *OrderBook, id string, tif protocol.TimeInForce, expire, ts int64) {
+	t.Helper()
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: id, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1,
+		TimeInForce: tif, ExpireTime: expire, Timestamp: ts,
+	})
+}
+
+// 定时指令按到期时间撤单，快照恢复后到期顺序不变
+func TestOrderBook_Expiry(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeWithTif(t, b, "gtc", protocol.TifGTC, 0, 100)
+	placeWithTif(t, b, "gtd1", protocol.TifGTD, 500, 100)
+	placeWithTif(t, b, "gtd2", protocol.TifGTD, 300, 100)
+	placeWithTif(t, b, "day", protocol.TifDAY, 0, 100)
+	placeWithTif(t, b, "cancelled", protocol.TifGTD, 200, 100)
+	placeWithTif(t, b, "bad", protocol.TifGTD, 50, 100)
+	logs := memLog.GetLogs()
+	assertInt64(t, protocol.ReasonInvalidExpireTime, int64(logs[len(logs)-1].RejectReason), "expire before timestamp")
+
+	//已撤销的订单在索引中留有条目，到期时跳过
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "cancelled", UserId: 1})
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, book := range []*OrderBook{b, restored} {
+		before := len(book.traderLog.(*MemoryLog).GetLogs())
+		sendCmd(t, book, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 499})
+		logs := book.traderLog.(*MemoryLog).GetLogs()[before:]
+		if len(logs) != 1 {
+			t.Fatalf("expected 1 expiry, got %d", len(logs))
+		}
+		assertString(t, "gtd2", logs[0].OrderId, "first expiry")
+		assertInt64(t, protocol.ReasonExpired, int64(logs[0].RejectReason), "expired reason")
+
+		sendCmd(t, book, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: daySeconds})
+		logs = book.traderLog.(*MemoryLog).GetLogs()[before+1:]
+		if len(logs) != 2 {
+			t.Fatalf("expected 2 expiries, got %d", len(logs))
+		}
+		assertString(t, "gtd1", logs[0].OrderId, "expiry order")
+		assertString(t, "day", logs[1].OrderId, "day order expires at utc midnight")
+		assertString(t, "gtc", book.bidQueue.PeakHeadOrder().Id, "gtc remains")
+		assertInt64(t, 1, book.bidQueue.OrderCount(), "only gtc left")
+	}
+}
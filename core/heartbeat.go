@@ -0,0 +1,87 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"sort"
+)
+
+// 心跳会话，session 为空时表示用户级
+type sessionKey struct {
+	userId  int64
+	session string
+}
+
+// 设置默认心跳超时(秒)，心跳指令未指定超时时使用
+func WithHeartbeatTimeout(seconds int64) OrderBookOption {
+	return func(b *OrderBook) {
+		if seconds > 0 {
+			b.heartbeatTimeout = seconds
+		}
+	}
+}
+
+// 心跳，开启或刷新撤单保护的截止时间
+func (b *OrderBook) handleHeartbeat(bean *protocol.HeartbeatCommand) {
+	if bean.UserId == 0 {
+		b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPayload, nil)
+		return
+	}
+	key := sessionKey{userId: bean.UserId, session: b.curSession}
+	if bean.Disable {
+		delete(b.heartbeats, key)
+		return
+	}
+	timeout := bean.Timeout
+	if timeout == 0 {
+		timeout = b.heartbeatTimeout
+	}
+	if timeout <= 0 {
+		b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPayload, nil)
+		return
+	}
+	//截止时间按定时指令的时钟计算，网关时间只在尚未收到定时指令时使用
+	now := b.lastTimerTime
+	if now == 0 {
+		now = bean.Timestamp
+	}
+	b.heartbeats[key] = now + timeout
+}
+
+// 撤销心跳超时会话的订单，保护随之关闭，需重新发送心跳开启
+func (b *OrderBook) expireHeartbeats(now int64, logs *[]*OrderBookLog) {
+	expired := make([]sessionKey, 0)
+	for key, deadline := range b.heartbeats {
+		if deadline <= now {
+			expired = append(expired, key)
+		}
+	}
+	//按用户、会话排序，保证重放时日志顺序一致
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].userId != expired[j].userId {
+			return expired[i].userId < expired[j].userId
+		}
+		return expired[i].session < expired[j].session
+	})
+	for _, key := range expired {
+		delete(b.heartbeats, key)
+		targets := b.collectOrders(key.userId, false, func(order *protocol.Order) bool {
+			return len(key.session) == 0 || order.Session == key.session
+		})
+		b.cancelOrders(targets, protocol.ReasonHeartbeatTimeout, now, logs)
+	}
+}
+
+// 按用户、会话排序，保证快照内容确定
+func (b *OrderBook) heartbeatSnapshot() []HeartbeatSnapshot {
+	result := make([]HeartbeatSnapshot, 0, len(b.heartbeats))
+	for key, deadline := range b.heartbeats {
+		result = append(result, HeartbeatSnapshot{UserId: key.userId, Session: key.session, Deadline: deadline})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].UserId != result[j].UserId {
+			return result[i].UserId < result[j].UserId
+		}
+		return result[i].Session < result[j].Session
+	})
+	return result
+}
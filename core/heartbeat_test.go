@@ -0,0 +1,102 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"testing"
+)
+
+func sendSessionCmd(t *testing.T, b *OrderBook, session string, cmdType protocol.CommandType, payload any) {
+	t.Helper()
+	bs, err := b.serializer.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	b.processCmd(&protocol.Command{MarketId: b.marketId, Type: cmdType, Payload: bs, Metadata: map[string]string{protocol.MetaSession: session}})
+}
+
+// 心跳超时后撤销会话的订单，刷新心跳推迟截止时间
+func TestOrderBook_HeartbeatSession(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	place := func(id, session string) {
+		sendSessionCmd(t, b, session, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 7,
+		})
+	}
+	place("o1", "s1")
+	place("o2", "s1")
+	place("o3", "s2")
+	sendSessionCmd(t, b, "s1", protocol.CmdHeartbeat, &protocol.HeartbeatCommand{UserId: 7, Timeout: 10, Timestamp: 100})
+	sendSessionCmd(t, b, "s1", protocol.CmdHeartbeat, &protocol.HeartbeatCommand{UserId: 7, Timeout: 10, Timestamp: 105})
+
+	before := len(memLog.GetLogs())
+	sendCmd(t, b, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 110})
+	assertInt64(t, int64(before), int64(len(memLog.GetLogs())), "refreshed heartbeat not expired")
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt64(t, 115, restored.heartbeats[sessionKey{userId: 7, session: "s1"}], "restored deadline")
+	assertString(t, "s1", restored.bidQueue.GetOrder("o1").Session, "restored order session")
+
+	sendCmd(t, b, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 115})
+	logs := memLog.GetLogs()[before:]
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 cancel logs, got %d", len(logs))
+	}
+	for i, id := range []string{"o1", "o2"} {
+		assertString(t, id, logs[i].OrderId, "session order cancelled")
+		assertInt64(t, protocol.ReasonHeartbeatTimeout, int64(logs[i].RejectReason), "cancel reason")
+	}
+	assertInt64(t, 1, b.bidQueue.OrderCount(), "other session untouched")
+	assertInt64(t, 0, int64(len(b.heartbeats)), "switch disarmed after firing")
+}
+
+// 用户级心跳撤销全部订单，关闭后不再撤单，未配置默认超时时拒绝
+func TestOrderBook_HeartbeatUser(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	sendCmd(t, b, protocol.CmdHeartbeat, &protocol.HeartbeatCommand{UserId: 7, Timestamp: 1})
+	assertInt64(t, protocol.ReasonInvalidPayload, int64(lastLog(memLog).RejectReason), "no default timeout")
+
+	b, memLog = newTestOrderBook()
+	WithHeartbeatTimeout(30)(b)
+	sendSessionCmd(t, b, "s1", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "o1", Side: protocol.Sell, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 7,
+	})
+	placeStop(t, b, "st1", protocol.Sell, protocol.TypeStopMarket, "90", "0", "1")
+	placeLimit(t, b, "o2", 8, protocol.Sell, "101", "1")
+	sendCmd(t, b, protocol.CmdHeartbeat, &protocol.HeartbeatCommand{UserId: 9, Timestamp: 1, Disable: true})
+	sendCmd(t, b, protocol.CmdHeartbeat, &protocol.HeartbeatCommand{UserId: 7, Timestamp: 1})
+	sendCmd(t, b, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 31})
+	assertString(t, "o1", lastLog(memLog).OrderId, "user-level cancels every session")
+	assertInt64(t, 1, b.triggers.count(), "disabled user keeps stops")
+	assertInt64(t, 1, b.askQueue.OrderCount(), "other user untouched")
+}
+
+// 截止时间按定时指令的时间计算，网关时钟超前不会推迟撤单
+func TestOrderBook_HeartbeatTimerClock(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "o1", 7, protocol.Buy, "100", "1")
+	sendCmd(t, b, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 100})
+	sendCmd(t, b, protocol.CmdHeartbeat, &protocol.HeartbeatCommand{UserId: 7, Timeout: 10, Timestamp: 1000})
+	assertInt64(t, 110, b.heartbeats[sessionKey{userId: 7}], "deadline from timer clock")
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt64(t, 100, restored.lastTimerTime, "restored timer clock")
+
+	sendCmd(t, b, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 110})
+	assertString(t, "o1", lastLog(memLog).OrderId, "cancelled on timer clock")
+	assertInt64(t, protocol.ReasonHeartbeatTimeout, int64(lastLog(memLog).RejectReason), "cancel reason")
+}
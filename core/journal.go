@@ -0,0 +1,481 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指令日志(WAL)，指令在撮合前先落盘
+type Journal interface {
+	Append(cmd *protocol.Command) error
+	// 一批指令处理完成
+	Flush() error
+	Close() error
+}
+
+type SyncPolicy uint8
+
+const (
+	SyncEveryCommand SyncPolicy = 0 //每条指令 fsync
+	SyncEveryBatch   SyncPolicy = 1 //每批指令 fsync
+	SyncInterval     SyncPolicy = 2 //定时 fsync
+)
+
+const (
+	journalSuffix        = ".wal"
+	journalHeaderSize    = 8 //length(4) + crc(4)
+	DefaultSegmentSize   = 64 << 20
+	DefaultSyncInterval  = 10 * time.Millisecond
+	maxJournalRecordSize = 64 << 20
+)
+
+var (
+	ErrJournalClosed  = errors.New("journal is closed")
+	ErrJournalFailed  = errors.New("journal failed")
+	ErrCorruptRecord  = errors.New("corrupt journal record")
+	journalCrcTable   = crc32.MakeTable(crc32.Castagnoli)
+	errShortRecordBuf = errors.New("short journal record")
+)
+
+type FileJournalConfig struct {
+	Dir          string
+	SegmentSize  int64         //单个文件最大字节数，超过后滚动
+	SyncPolicy   SyncPolicy    //fsync 策略
+	SyncInterval time.Duration //SyncInterval 策略下的刷盘间隔
+}
+
+// 文件日志，按段滚动，记录格式: length(4) | crc32c(4) | body
+type FileJournal struct {
+	mu        sync.Mutex
+	cfg       FileJournalConfig
+	file      *os.File
+	path      string //当前段文件路径
+	writer    *bufio.Writer
+	fileSize  int64
+	synced    int64 //当前段已 fsync 的字节数
+	failed    error //写入或刷盘失败后不再接受写入
+	nextIndex int64 //下一个段文件编号
+	dirty     bool
+	closed    bool
+	buf       []byte
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func WithJournal(journal Journal) OrderBookOption {
+	return func(b *OrderBook) {
+		b.journal = journal
+	}
+}
+
+// 打开日志目录，新写入总是追加到新的段文件
+// 最后一个段尾部崩溃残留的不完整记录先截掉，否则新段写入后它不再位于日志末尾，读取时会被当作损坏
+func OpenFileJournal(cfg FileJournalConfig) (*FileJournal, error) {
+	if len(cfg.Dir) == 0 {
+		return nil, errors.New("journal dir is empty")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	segments, err := journalSegments(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	j := &FileJournal{
+		cfg:       cfg,
+		nextIndex: 1,
+	}
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		if err := truncateTornTail(last.path); err != nil {
+			return nil, err
+		}
+		j.nextIndex = last.index + 1
+	}
+	if cfg.SyncPolicy == SyncInterval {
+		j.stop = make(chan struct{})
+		j.stopped = make(chan struct{})
+		go j.syncLoop()
+	}
+	return j, nil
+}
+
+func (j *FileJournal) Append(cmd *protocol.Command) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return ErrJournalClosed
+	}
+	if j.failed != nil {
+		return j.failed
+	}
+	j.buf = encodeCommand(j.buf[:0], cmd)
+	recordSize := int64(journalHeaderSize + len(j.buf))
+	if j.file == nil || (j.fileSize > 0 && j.fileSize+recordSize > j.cfg.SegmentSize) {
+		if err := j.roll(); err != nil {
+			return j.fail(err)
+		}
+	}
+	var header [journalHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(j.buf)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(j.buf, journalCrcTable))
+	if _, err := j.writer.Write(header[:]); err != nil {
+		return j.fail(err)
+	}
+	if _, err := j.writer.Write(j.buf); err != nil {
+		return j.fail(err)
+	}
+	j.fileSize += recordSize
+	j.dirty = true
+	if j.cfg.SyncPolicy == SyncEveryCommand {
+		return j.sync()
+	}
+	return nil
+}
+
+func (j *FileJournal) Flush() error {
+	if j.cfg.SyncPolicy != SyncEveryBatch {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return ErrJournalClosed
+	}
+	if j.failed != nil {
+		return j.failed
+	}
+	return j.sync()
+}
+
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	err := j.closeSegment()
+	j.mu.Unlock()
+	if j.stop != nil {
+		close(j.stop)
+		<-j.stopped
+	}
+	return err
+}
+
+// 定时刷盘
+func (j *FileJournal) syncLoop() {
+	defer close(j.stopped)
+	ticker := time.NewTicker(j.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			if !j.closed && j.failed == nil {
+				j.sync()
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+func (j *FileJournal) sync() error {
+	if !j.dirty || j.file == nil {
+		return nil
+	}
+	if err := j.writer.Flush(); err != nil {
+		return j.fail(err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return j.fail(err)
+	}
+	j.synced = j.fileSize
+	j.dirty = false
+	return nil
+}
+
+// 写入失败后丢弃缓冲区并截掉未确认落盘的记录，之后拒绝写入，避免关闭时把已拒绝的指令刷进日志
+func (j *FileJournal) fail(err error) error {
+	if j.failed != nil {
+		return j.failed
+	}
+	j.failed = fmt.Errorf("%w: %v", ErrJournalFailed, err)
+	if j.file == nil {
+		return j.failed
+	}
+	j.writer.Reset(j.file)
+	j.dirty = false
+	if e := os.Truncate(j.path, j.synced); e != nil {
+		return fmt.Errorf("%w: %v, truncate: %v", ErrJournalFailed, err, e)
+	}
+	j.fileSize = j.synced
+	return j.failed
+}
+
+// 关闭当前段并创建下一个段文件
+func (j *FileJournal) roll() error {
+	if err := j.closeSegment(); err != nil {
+		return err
+	}
+	name := filepath.Join(j.cfg.Dir, fmt.Sprintf("%020d%s", j.nextIndex, journalSuffix))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	j.nextIndex++
+	j.file = file
+	j.path = name
+	j.fileSize = 0
+	j.synced = 0
+	if j.writer == nil {
+		j.writer = bufio.NewWriterSize(file, 64<<10)
+	} else {
+		j.writer.Reset(file)
+	}
+	return nil
+}
+
+func (j *FileJournal) closeSegment() error {
+	if j.file == nil {
+		return nil
+	}
+	var err error
+	if j.failed == nil {
+		j.dirty = true
+		err = j.sync()
+	}
+	if e := j.file.Close(); err == nil {
+		err = e
+	}
+	j.file = nil
+	return err
+}
+
+// 按顺序读取目录下所有日志记录
+// 只有最后一个段末尾的不完整记录视为崩溃残留并忽略，其他位置的残缺或校验失败返回 ErrCorruptRecord
+func ReadJournal(dir string, fn func(cmd *protocol.Command) error) error {
+	segments, err := journalSegments(dir)
+	if err != nil {
+		return err
+	}
+	for i, segment := range segments {
+		if _, err := readJournalSegment(segment.path, i == len(segments)-1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 截掉段尾崩溃残留的不完整记录
+func truncateTornTail(path string) error {
+	size, err := readJournalSegment(path, true, nil)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if size == info.Size() {
+		return nil
+	}
+	return os.Truncate(path, size)
+}
+
+// 读取一个段文件，返回完整记录的总字节数
+// tail 表示最后一个段，只有延伸到文件末尾的残缺记录(写入中途崩溃)才会被忽略
+func readJournalSegment(path string, tail bool, fn func(cmd *protocol.Command) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	fileSize := info.Size()
+	var offset int64
+	torn := func(reason string) (int64, error) {
+		if tail {
+			return offset, nil
+		}
+		return offset, fmt.Errorf("%w: %s at %d: %s", ErrCorruptRecord, path, offset, reason)
+	}
+	reader := bufio.NewReaderSize(file, 64<<10)
+	var header [journalHeaderSize]byte
+	var body []byte
+	for offset < fileSize {
+		if fileSize-offset < journalHeaderSize {
+			return torn("short header")
+		}
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return offset, err
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		end := offset + journalHeaderSize + int64(size)
+		if end > fileSize {
+			return torn("short record")
+		}
+		if size > maxJournalRecordSize {
+			return offset, fmt.Errorf("%w: %s at %d: record size %d", ErrCorruptRecord, path, offset, size)
+		}
+		if cap(body) < int(size) {
+			body = make([]byte, size)
+		}
+		body = body[:size]
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return offset, err
+		}
+		if crc32.Checksum(body, journalCrcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			//最后一条记录的长度已写入但内容未写完整
+			if end == fileSize {
+				return torn("checksum mismatch")
+			}
+			return offset, fmt.Errorf("%w: %s at %d: checksum mismatch", ErrCorruptRecord, path, offset)
+		}
+		cmd, err := decodeCommand(body)
+		if err != nil {
+			return offset, fmt.Errorf("%w: %s at %d: %v", ErrCorruptRecord, path, offset, err)
+		}
+		if fn != nil {
+			if err := fn(cmd); err != nil {
+				return offset, err
+			}
+		}
+		offset = end
+	}
+	return offset, nil
+}
+
+type journalSegment struct {
+	index int64
+	path  string
+}
+
+// 按编号排序的段文件
+func journalSegments(dir string) ([]journalSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]journalSegment, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, journalSuffix) {
+			continue
+		}
+		index, err := strconv.ParseInt(strings.TrimSuffix(name, journalSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, journalSegment{index: index, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(segments, func(i, k int) bool {
+		return segments[i].index < segments[k].index
+	})
+	return segments, nil
+}
+
+// 指令编码: version | type | seqId | marketId | payload | metadata | localSeqId
+// localSeqId 追加在末尾，不含该字段的旧记录按 0 读取
+func encodeCommand(buf []byte, cmd *protocol.Command) []byte {
+	buf = append(buf, cmd.Version, byte(cmd.Type))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(cmd.SeqId))
+	buf = appendBytes(buf, []byte(cmd.MarketId))
+	buf = appendBytes(buf, cmd.Payload)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(cmd.Metadata)))
+	keys := make([]string, 0, len(cmd.Metadata))
+	for k := range cmd.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf = appendBytes(buf, []byte(k))
+		buf = appendBytes(buf, []byte(cmd.Metadata[k]))
+	}
+	return binary.BigEndian.AppendUint64(buf, uint64(cmd.LocalSeqId))
+}
+
+func decodeCommand(data []byte) (*protocol.Command, error) {
+	if len(data) < 10 {
+		return nil, errShortRecordBuf
+	}
+	cmd := &protocol.Command{
+		Version: data[0],
+		Type:    protocol.CommandType(data[1]),
+		SeqId:   int64(binary.BigEndian.Uint64(data[2:10])),
+	}
+	data = data[10:]
+	marketId, data, err := readBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	cmd.MarketId = string(marketId)
+	payload, data, err := readBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Payload = append([]byte(nil), payload...)
+	if len(data) < 4 {
+		return nil, errShortRecordBuf
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if count > 0 {
+		cmd.Metadata = make(map[string]string, count)
+	}
+	for i := uint32(0); i < count; i++ {
+		var k, v []byte
+		if k, data, err = readBytes(data); err != nil {
+			return nil, err
+		}
+		if v, data, err = readBytes(data); err != nil {
+			return nil, err
+		}
+		cmd.Metadata[string(k)] = string(v)
+	}
+	if len(data) >= 8 {
+		cmd.LocalSeqId = int64(binary.BigEndian.Uint64(data))
+	}
+	return cmd, nil
+}
+
+func appendBytes(buf []byte, bs []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(bs)))
+	return append(buf, bs...)
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errShortRecordBuf
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint32(len(data)) < n {
+		return nil, nil, errShortRecordBuf
+	}
+	return data[:n], data[n:], nil
+}
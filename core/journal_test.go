@@ -0,0 +1,207 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写入后按顺序读回，段文件按大小滚动
+func TestFileJournal_AppendAndRead(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := OpenFileJournal(FileJournalConfig{Dir: dir, SegmentSize: 128, SyncPolicy: SyncEveryBatch})
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	for i := int64(1); i <= 20; i++ {
+		cmd := &protocol.Command{
+			MarketId: "BTC-USDT",
+			SeqId:    i,
+			Type:     protocol.CmdPlaceOrder,
+			Payload:  []byte(`{"orderId":"o"}`),
+			Metadata: map[string]string{"session": "s1"},
+		}
+		if err := journal.Append(cmd); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	segments, _ := journalSegments(dir)
+	if len(segments) < 2 {
+		t.Fatalf("expected segment roll, got %d segments", len(segments))
+	}
+
+	var seqIds []int64
+	err = ReadJournal(dir, func(cmd *protocol.Command) error {
+		seqIds = append(seqIds, cmd.SeqId)
+		assertString(t, "BTC-USDT", cmd.MarketId, "marketId")
+		assertString(t, "s1", cmd.Metadata["session"], "metadata")
+		return nil
+	})
+	assertError(t, err, false, "read journal")
+	assertInt64(t, 20, int64(len(seqIds)), "record count")
+	for i, seqId := range seqIds {
+		assertInt64(t, int64(i+1), seqId, "seqId order")
+	}
+}
+
+// 段尾残缺记录被忽略
+func TestFileJournal_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := OpenFileJournal(FileJournalConfig{Dir: dir, SyncPolicy: SyncEveryCommand})
+	for i := int64(1); i <= 3; i++ {
+		journal.Append(&protocol.Command{SeqId: i, Type: protocol.CmdCancelOrder})
+	}
+	journal.Close()
+
+	path := filepath.Join(dir, "00000000000000000001.wal")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat segment: %v", err)
+	}
+	os.Truncate(path, info.Size()-3)
+
+	count := 0
+	ReadJournal(dir, func(cmd *protocol.Command) error {
+		count++
+		return nil
+	})
+	assertInt64(t, 2, int64(count), "records before torn tail")
+
+	//重新打开时截掉残缺记录，新段写入后仍可完整读取
+	journal, _ = OpenFileJournal(FileJournalConfig{Dir: dir, SyncPolicy: SyncEveryCommand})
+	journal.Append(&protocol.Command{SeqId: 3, Type: protocol.CmdCancelOrder})
+	journal.Close()
+	count = 0
+	err = ReadJournal(dir, func(cmd *protocol.Command) error {
+		count++
+		return nil
+	})
+	assertError(t, err, false, "read after reopen")
+	assertInt64(t, 3, int64(count), "records after reopen")
+}
+
+// 非日志末尾的损坏记录返回错误，不会跳过后续记录
+func TestFileJournal_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := OpenFileJournal(FileJournalConfig{Dir: dir, SegmentSize: 128, SyncPolicy: SyncEveryCommand})
+	for i := int64(1); i <= 6; i++ {
+		journal.Append(&protocol.Command{SeqId: i, Type: protocol.CmdCancelOrder, Payload: []byte(`{"orderId":"o"}`)})
+	}
+	journal.Close()
+	segments, _ := journalSegments(dir)
+	if len(segments) < 2 {
+		t.Fatalf("expected segment roll, got %d segments", len(segments))
+	}
+
+	for _, segment := range []journalSegment{segments[0], segments[len(segments)-1]} {
+		data, _ := os.ReadFile(segment.path)
+		//第一条记录内容损坏，后面还有记录
+		data[journalHeaderSize] ^= 0xff
+		extra := append([]byte(nil), data...)
+		os.WriteFile(segment.path, append(data, extra...), 0o644)
+		err := ReadJournal(dir, func(cmd *protocol.Command) error { return nil })
+		if !errors.Is(err, ErrCorruptRecord) {
+			t.Fatalf("%s: expected corrupt record, got %v", segment.path, err)
+		}
+		extra[journalHeaderSize] ^= 0xff
+		os.WriteFile(segment.path, extra, 0o644)
+	}
+
+	//非最后一个段的段尾残缺
+	info, _ := os.Stat(segments[0].path)
+	os.Truncate(segments[0].path, info.Size()-3)
+	if err := ReadJournal(dir, func(cmd *protocol.Command) error { return nil }); !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("expected corrupt record, got %v", err)
+	}
+}
+
+type failingJournal struct{}
+
+func (failingJournal) Append(*protocol.Command) error { return nil }
+func (failingJournal) Flush() error                   { return errors.New("disk full") }
+func (failingJournal) Close() error                   { return nil }
+
+// 刷盘失败时停止订单簿
+func TestOrderBook_JournalFlushFailed(t *testing.T) {
+	memLog := NewMemoryLog()
+	b := NewOrderBook("BTC-USDT", memLog, WithJournal(failingJournal{}))
+	placeLimit(t, b, "o1", 1, protocol.Buy, "100", "1")
+	b.OnBatchEnd()
+	assertInt64(t, int64(protocol.OrderBookStop), int64(b.state), "book stopped")
+	log := lastLog(memLog)
+	assertInt64(t, int64(protocol.LogTypeState), int64(log.Type), "state log")
+	assertInt64(t, protocol.ReasonJournalFailed, int64(log.RejectReason), "state reason")
+	assertInt64(t, 0, log.SeqId, "state log not journaled")
+
+	placeLimit(t, b, "o2", 1, protocol.Buy, "100", "1")
+	assertInt64(t, protocol.ReasonStateHadDone, int64(lastLog(memLog).RejectReason), "stopped book rejects")
+}
+
+type flushJournal struct{ err error }
+
+func (j *flushJournal) Append(*protocol.Command) error { return nil }
+func (j *flushJournal) Flush() error                   { return j.err }
+func (j *flushJournal) Close() error                   { return nil }
+
+// 指令结果在本批刷盘后回调，刷盘失败时按日志失败返回
+func TestOrderBook_AckAfterFlush(t *testing.T) {
+	journal := &flushJournal{}
+	b := NewOrderBook("BTC-USDT", NewMemoryLog(), WithJournal(journal))
+	place := func(seqId int64, orderId string) *protocol.CommandResult {
+		var result *protocol.CommandResult
+		bs, _ := b.serializer.Marshal(&protocol.PlaceOrderCommand{
+			OrderId: orderId, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1,
+		})
+		b.OnEvent(&protocol.InputEvent{
+			Cmd: &protocol.Command{MarketId: b.marketId, SeqId: seqId, Type: protocol.CmdPlaceOrder, Payload: bs},
+			Ack: func(r *protocol.CommandResult) { result = r },
+		})
+		if result != nil {
+			t.Fatalf("%s acked before flush", orderId)
+		}
+		b.OnBatchEnd()
+		return result
+	}
+
+	result := place(1, "o1")
+	if result == nil || !result.Accepted {
+		t.Fatalf("expected accepted result after flush, got %+v", result)
+	}
+	journal.err = errors.New("disk full")
+	result = place(2, "o2")
+	if result == nil || result.Accepted {
+		t.Fatalf("expected rejected result after failed flush, got %+v", result)
+	}
+	assertInt64(t, protocol.ReasonJournalFailed, int64(result.RejectReason), "ack reason")
+}
+
+// 订单簿先写日志再撮合，未指定 SeqId 的指令按本地顺序编号
+func TestOrderBook_Journal(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := OpenFileJournal(FileJournalConfig{Dir: dir, SyncPolicy: SyncEveryBatch})
+	b := NewOrderBook("BTC-USDT", NewMemoryLog(), WithJournal(journal))
+	for _, id := range []string{"o1", "o2"} {
+		bs, _ := b.serializer.Marshal(&protocol.PlaceOrderCommand{
+			OrderId: id, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "1", Size: "1",
+		})
+		b.OnEvent(&protocol.InputEvent{Cmd: &protocol.Command{MarketId: "BTC-USDT", Type: protocol.CmdPlaceOrder, Payload: bs}})
+	}
+	b.OnBatchEnd()
+	journal.Close()
+
+	var cmds []*protocol.Command
+	ReadJournal(dir, func(cmd *protocol.Command) error {
+		cmds = append(cmds, cmd)
+		return nil
+	})
+	assertInt64(t, 2, int64(len(cmds)), "journaled commands")
+	assertInt64(t, 0, cmds[1].SeqId, "no upstream seqId")
+	assertInt64(t, 2, cmds[1].LocalSeqId, "local seqId")
+	assertInt64(t, 0, b.lastCmdSeqId.Load(), "lastCmdSeqId")
+	assertInt64(t, 2, b.lastLocalSeqId, "lastLocalSeqId")
+}
@@ -24,8 +24,14 @@ type HandlerEvent[T any] interface {
 	OnEvent(event *T)
 }
 
+// 可选接口，暂无待处理事件时回调，用于批量刷盘等
+type BatchHandler interface {
+	OnBatchEnd()
+}
+
 func NewRingBuffer[T any](capacity int64, handler HandlerEvent[T]) *RingBuffer[T] {
-	if capacity <= 0 || capacity%2 != 0 {
+	//capacity 必须是2的幂，下标通过 seq&bufferMask 计算
+	if capacity <= 0 || capacity&(capacity-1) != 0 {
 		panic("invalid capacity")
 	}
 	rb := &RingBuffer[T]{
@@ -90,10 +96,15 @@ func (rb *RingBuffer[T]) Start() {
 
 func (rb *RingBuffer[T]) consumerLoop() {
 	nextConsumerSeq := rb.consumerSeq.Load() + 1
+	batchHandler, _ := rb.handler.(BatchHandler)
+	inBatch := false
 	for {
 		currentProducerSeq := rb.producerSeq.Load()
 		if rb.shutDown.Load() {
 			rb.processRemainEvent(nextConsumerSeq)
+			if batchHandler != nil {
+				batchHandler.OnBatchEnd()
+			}
 			return
 		}
 		process := false
@@ -109,8 +120,13 @@ func (rb *RingBuffer[T]) consumerLoop() {
 			rb.consumerSeq.Store(nextConsumerSeq)
 			nextConsumerSeq++
 			process = true
+			inBatch = true
 		}
 		if !process {
+			if inBatch && batchHandler != nil {
+				batchHandler.OnBatchEnd()
+			}
+			inBatch = false
 			runtime.Gosched()
 		}
 	}
@@ -137,7 +153,7 @@ func (rb *RingBuffer[T]) Shutdown(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			if rb.consumerSeq.Load() >= rb.consumerSeq.Load() {
+			if rb.consumerSeq.Load() >= rb.producerSeq.Load() {
 				return nil
 			}
 			runtime.Gosched()
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"errors"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 不限制小数位
+const NoDecimalLimit int32 = -1
+
+var ErrInvalidMarketConfig = errors.New("invalid market config")
+
+// 交易对下单规则，零值表示不限制
+type MarketConfig struct {
+	TickSize      udecimal.Decimal
+	StepSize      udecimal.Decimal
+	MinSize       udecimal.Decimal
+	MaxSize       udecimal.Decimal
+	MinNotional   udecimal.Decimal
+	MaxNotional   udecimal.Decimal
+	PriceDecimals int32 //NoDecimalLimit 不限制
+	SizeDecimals  int32
+	//价格保护
+	PriceBand      udecimal.Decimal //百分比
+	BandReference  protocol.BandReference
+	BreakerPercent udecimal.Decimal //百分比
+	BreakerWindow  int64            //秒
+}
+
+func DefaultMarketConfig() MarketConfig {
+	return MarketConfig{PriceDecimals: NoDecimalLimit, SizeDecimals: NoDecimalLimit}
+}
+
+// 设置下单规则
+func WithMarketConfig(config MarketConfig) OrderBookOption {
+	return func(b *OrderBook) {
+		b.config = config
+	}
+}
+
+// 在当前配置上应用规则，返回新配置，任一字段非法时整体失败
+func (c MarketConfig) Apply(rules *protocol.TradingRules) (MarketConfig, error) {
+	fields := []struct {
+		value  string
+		target *udecimal.Decimal
+	}{
+		{rules.TickSize, &c.TickSize},
+		{rules.StepSize, &c.StepSize},
+		{rules.MinSize, &c.MinSize},
+		{rules.MaxSize, &c.MaxSize},
+		{rules.MinNotional, &c.MinNotional},
+		{rules.MaxNotional, &c.MaxNotional},
+		{rules.PriceBand, &c.PriceBand},
+		{rules.BreakerPercent, &c.BreakerPercent},
+	}
+	for _, f := range fields {
+		if len(f.value) == 0 {
+			continue
+		}
+		v, err := udecimal.Parse(f.value)
+		if err != nil || v.IsNeg() {
+			return c, ErrInvalidMarketConfig
+		}
+		*f.target = v
+	}
+	if rules.PriceDecimals != nil {
+		c.PriceDecimals = *rules.PriceDecimals
+	}
+	if rules.SizeDecimals != nil {
+		c.SizeDecimals = *rules.SizeDecimals
+	}
+	if rules.BandReference != nil {
+		c.BandReference = *rules.BandReference
+	}
+	if rules.BreakerWindow != nil {
+		c.BreakerWindow = *rules.BreakerWindow
+	}
+	if err := c.validate(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func (c MarketConfig) validate() error {
+	if c.PriceDecimals < NoDecimalLimit || c.SizeDecimals < NoDecimalLimit {
+		return ErrInvalidMarketConfig
+	}
+	if !c.MaxSize.IsZero() && c.MinSize.GreaterThan(c.MaxSize) {
+		return ErrInvalidMarketConfig
+	}
+	if !c.MaxNotional.IsZero() && c.MinNotional.GreaterThan(c.MaxNotional) {
+		return ErrInvalidMarketConfig
+	}
+	if c.BandReference > protocol.BandRefMid || c.BreakerWindow < 0 {
+		return ErrInvalidMarketConfig
+	}
+	//开启熔断必须设置窗口
+	if !c.BreakerPercent.IsZero() && c.BreakerWindow == 0 {
+		return ErrInvalidMarketConfig
+	}
+	return nil
+}
+
+// 校验价格，price 为 0 时(市价单)不校验
+func (c *MarketConfig) checkPrice(price udecimal.Decimal) int32 {
+	if price.IsZero() {
+		return 0
+	}
+	if !withinDecimals(price, c.PriceDecimals) {
+		return protocol.ReasonPriceDecimals
+	}
+	if !isMultiple(price, c.TickSize) {
+		return protocol.ReasonInvalidTick
+	}
+	return 0
+}
+
+// 校验数量，size 为 0 时(按金额的市价单)不校验
+func (c *MarketConfig) checkSize(size udecimal.Decimal) int32 {
+	if size.IsZero() {
+		return 0
+	}
+	if !withinDecimals(size, c.SizeDecimals) {
+		return protocol.ReasonSizeDecimals
+	}
+	if !isMultiple(size, c.StepSize) {
+		return protocol.ReasonInvalidStep
+	}
+	if size.LessThan(c.MinSize) {
+		return protocol.ReasonSizeTooSmall
+	}
+	if !c.MaxSize.IsZero() && size.GreaterThan(c.MaxSize) {
+		return protocol.ReasonSizeTooLarge
+	}
+	return 0
+}
+
+// 校验金额，notional 为 0 时不校验
+func (c *MarketConfig) checkNotional(notional udecimal.Decimal) int32 {
+	if notional.IsZero() {
+		return 0
+	}
+	if notional.LessThan(c.MinNotional) {
+		return protocol.ReasonNotionalTooSmall
+	}
+	if !c.MaxNotional.IsZero() && notional.GreaterThan(c.MaxNotional) {
+		return protocol.ReasonNotionalTooLarge
+	}
+	return 0
+}
+
+// 校验下单，返回拒绝原因，0 表示通过
+func (c *MarketConfig) checkOrder(price, size, quoteSize udecimal.Decimal) int32 {
+	if reason := c.checkPrice(price); reason != 0 {
+		return reason
+	}
+	if reason := c.checkSize(size); reason != 0 {
+		return reason
+	}
+	if !quoteSize.IsZero() {
+		return c.checkNotional(quoteSize)
+	}
+	return c.checkNotional(price.Mul(size))
+}
+
+// 价格按最小变动单位取整，up 为 true 时向上取整
+func (c *MarketConfig) roundPrice(price udecimal.Decimal, up bool) udecimal.Decimal {
+	if !c.TickSize.IsZero() {
+		ticks, err := price.Div(c.TickSize)
+		if err != nil {
+			return price
+		}
+		if up {
+			ticks = ticks.Ceil()
+		} else {
+			ticks = ticks.Floor()
+		}
+		return ticks.Mul(c.TickSize)
+	}
+	if c.PriceDecimals != NoDecimalLimit && c.PriceDecimals <= 19 {
+		truncated := price.Trunc(uint8(c.PriceDecimals))
+		if up && !truncated.Equal(price) {
+			truncated = truncated.Add(udecimal.MustFromInt64(1, uint8(c.PriceDecimals)))
+		}
+		return truncated
+	}
+	return price
+}
+
+func withinDecimals(d udecimal.Decimal, decimals int32) bool {
+	if decimals == NoDecimalLimit {
+		return true
+	}
+	if decimals > 19 {
+		return true
+	}
+	return d.Trunc(uint8(decimals)).Equal(d)
+}
+
+func isMultiple(d, unit udecimal.Decimal) bool {
+	if unit.IsZero() {
+		return true
+	}
+	mod, err := d.Mod(unit)
+	return err == nil && mod.IsZero()
+}
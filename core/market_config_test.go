@@ -0,0 +1,91 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"testing"
+)
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+func newRuledOrderBook(t *testing.T) (*OrderBook, *MemoryLog) {
+	t.Helper()
+	config, err := DefaultMarketConfig().Apply(&protocol.TradingRules{
+		TickSize:      "0.5",
+		StepSize:      "0.01",
+		MinSize:       "0.1",
+		MaxSize:       "100",
+		MinNotional:   "10",
+		MaxNotional:   "5000",
+		PriceDecimals: int32Ptr(1),
+		SizeDecimals:  int32Ptr(2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memLog := NewMemoryLog()
+	return NewOrderBook("BTC-USDT", memLog, WithMarketConfig(config)), memLog
+}
+
+// 下单按交易规则校验
+func TestOrderBook_TradingRules(t *testing.T) {
+	cases := []struct {
+		price, size, quoteSize string
+		orderType              protocol.OrderType
+		reason                 int32
+	}{
+		{price: "100", size: "1", orderType: protocol.TypeLimit},
+		{price: "100.25", size: "1", orderType: protocol.TypeLimit, reason: protocol.ReasonPriceDecimals},
+		{price: "100.3", size: "1", orderType: protocol.TypeLimit, reason: protocol.ReasonInvalidTick},
+		{price: "100", size: "1.005", orderType: protocol.TypeLimit, reason: protocol.ReasonSizeDecimals},
+		{price: "100", size: "0.05", orderType: protocol.TypeLimit, reason: protocol.ReasonSizeTooSmall},
+		{price: "1", size: "101", orderType: protocol.TypeLimit, reason: protocol.ReasonSizeTooLarge},
+		{price: "50", size: "0.1", orderType: protocol.TypeLimit, reason: protocol.ReasonNotionalTooSmall},
+		{price: "100", size: "60", orderType: protocol.TypeLimit, reason: protocol.ReasonNotionalTooLarge},
+		{price: "0", size: "0", quoteSize: "5", orderType: protocol.TypeMarket, reason: protocol.ReasonNotionalTooSmall},
+		{price: "0", size: "1", orderType: protocol.TypeLimit, reason: protocol.ReasonInvalidPrice},
+		{price: "-1", size: "1", orderType: protocol.TypeIOC, reason: protocol.ReasonInvalidPrice},
+		{price: "0", size: "1", orderType: protocol.TypeFOK, reason: protocol.ReasonInvalidPrice},
+		{price: "0", size: "1", orderType: protocol.TypePostOnly, reason: protocol.ReasonInvalidPrice},
+	}
+	for i, c := range cases {
+		b, memLog := newRuledOrderBook(t)
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: "o1", Side: protocol.Buy, OrderType: c.orderType, Price: c.price, Size: c.size, QuoteSize: c.quoteSize, UserId: 1,
+		})
+		log := memLog.GetLogs()[0]
+		if c.reason == 0 {
+			if log.Type != protocol.LogTypeOpen {
+				t.Errorf("case %d: expected open, got %d", i, log.Type)
+			}
+			continue
+		}
+		if log.Type != protocol.LogTypeReject || log.RejectReason != c.reason {
+			t.Errorf("case %d: expected reject %d, got type %d reason %d", i, c.reason, log.Type, log.RejectReason)
+		}
+	}
+}
+
+// 改单同样校验，配置更新任一字段非法时整体不生效
+func TestOrderBook_TradingRulesAmendAndUpdate(t *testing.T) {
+	b, memLog := newRuledOrderBook(t)
+	placeLimit(t, b, "o1", 1, protocol.Buy, "100", "1")
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "o1", UserId: 1, NewPrice: "100.2"})
+	logs := memLog.GetLogs()
+	assertInt64(t, protocol.ReasonInvalidTick, int64(logs[1].RejectReason), "amend tick")
+
+	sendCmd(t, b, protocol.CmdUpdateConfig, &protocol.UpdateConfigCommand{
+		TradingRules: protocol.TradingRules{TickSize: "0.1", MinSize: "200"},
+	})
+	logs = memLog.GetLogs()
+	assertInt64(t, protocol.ReasonInvalidPayload, int64(logs[2].RejectReason), "min above max")
+	assertString(t, "0.5", b.config.TickSize.String(), "tick unchanged")
+
+	sendCmd(t, b, protocol.CmdUpdateConfig, &protocol.UpdateConfigCommand{
+		TradingRules: protocol.TradingRules{TickSize: "0.1", MaxNotional: "0"},
+	})
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "o1", UserId: 1, NewPrice: "100.2", NewSize: "80"})
+	logs = memLog.GetLogs()
+	assertLogTypes(t, logs, protocol.LogTypeOpen, protocol.LogTypeReject, protocol.LogTypeReject, protocol.LogTypeAmend, protocol.LogTypeOpen)
+}
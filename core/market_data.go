@@ -0,0 +1,105 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"sync"
+
+	"github.com/quagmt/udecimal"
+)
+
+// L2 增量行情订阅
+type PushDepth interface {
+	PublishDepth([]*UpdateEvent)
+}
+
+func WithDepthFeed(feed PushDepth) OrderBookOption {
+	return func(b *OrderBook) {
+		b.depthFeed = feed
+	}
+}
+
+type depthKey struct {
+	side  protocol.Side
+	price udecimal.Decimal
+}
+
+// 记录本条指令内发生变化的价格档位
+func (b *OrderBook) bindDepthListener() {
+	if b.depthFeed == nil {
+		return
+	}
+	if b.depthSeen == nil {
+		b.depthSeen = make(map[depthKey]struct{})
+	}
+	listener := func(side protocol.Side, price udecimal.Decimal) {
+		key := depthKey{side: side, price: price}
+		if _, ok := b.depthSeen[key]; ok {
+			return
+		}
+		b.depthSeen[key] = struct{}{}
+		b.depthDirty = append(b.depthDirty, key)
+	}
+	b.bidQueue.listener = listener
+	b.askQueue.listener = listener
+}
+
+// 指令处理完后推送行情
+func (b *OrderBook) flushMarketData() {
+	b.flushDepth()
+	b.flushL3()
+}
+
+// 指令处理完后按档位合并推送，同一档位只推送最终状态
+func (b *OrderBook) flushDepth() {
+	if len(b.depthDirty) == 0 {
+		return
+	}
+	if !b.replaying {
+		updates := make([]*UpdateEvent, 0, len(b.depthDirty))
+		for _, key := range b.depthDirty {
+			q := b.bidQueue
+			if key.side == protocol.Sell {
+				q = b.askQueue
+			}
+			size, count := q.Level(key.price)
+			updates = append(updates, &UpdateEvent{
+				MarketId:   b.marketId,
+				CmdSeqId:   b.curCmdSeqId,
+				LocalSeqId: b.curLocalSeqId,
+				Side:       key.side,
+				Price:      key.price.String(),
+				Size:       size.String(),
+				Count:      count,
+			})
+		}
+		b.depthFeed.PublishDepth(updates)
+	}
+	for _, key := range b.depthDirty {
+		delete(b.depthSeen, key)
+	}
+	b.depthDirty = b.depthDirty[:0]
+}
+
+// 内存 L2 订阅，测试用
+type MemoryDepth struct {
+	mu      sync.Mutex
+	Updates []*UpdateEvent
+}
+
+func NewMemoryDepth() *MemoryDepth {
+	return &MemoryDepth{}
+}
+
+func (md *MemoryDepth) PublishDepth(updates []*UpdateEvent) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	md.Updates = append(md.Updates, updates...)
+}
+
+func (md *MemoryDepth) GetUpdates() []*UpdateEvent {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	updates := make([]*UpdateEvent, len(md.Updates))
+	copy(updates, md.Updates)
+	return updates
+}
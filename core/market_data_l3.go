@@ -0,0 +1,357 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/quagmt/udecimal"
+)
+
+type L3EventType uint8
+
+const (
+	L3Add     L3EventType = 1 //新挂单(含冰山补货)，优先级排在档位末尾
+	L3Reduce  L3EventType = 2 //改单减量，保留优先级
+	L3Execute L3EventType = 3 //成交，Size 为成交数量，剩余为 0 时订单移除
+	L3Delete  L3EventType = 4 //撤单
+)
+
+// 逐笔委托(L3)事件，Handle 为匿名订单句柄，不暴露订单ID和用户
+type L3Event struct {
+	MarketId string `json:"marketId"`
+	Seq      int64  `json:"seq"`      //L3 序号，连续递增，恢复后接续
+	CmdSeqId int64  `json:"cmdSeqId"` //产生事件的指令ID
+	//产生事件的本地编号指令ID，CmdSeqId 为 0 时有效
+	LocalSeqId int64         `json:"localSeqId"`
+	Type       L3EventType   `json:"type"`
+	Handle     int64         `json:"handle"`
+	Side       protocol.Side `json:"side"`
+	Price      string        `json:"price"`
+	Size       string        `json:"size"`
+	TradeId    int64         `json:"tradeId"` //just type=execute
+}
+
+type L3Order struct {
+	Handle int64  `json:"handle"`
+	Price  string `json:"price"`
+	Size   string `json:"size"`
+}
+
+// 全量 L3 快照，Seq 为快照包含的最后一个事件序号，订单按优先级排列
+type L3Snapshot struct {
+	MarketId string     `json:"marketId"`
+	Seq      int64      `json:"seq"`
+	Bids     []*L3Order `json:"bids"`
+	Asks     []*L3Order `json:"asks"`
+}
+
+// L3 行情订阅
+type PushL3 interface {
+	PublishL3([]*L3Event)
+	PublishL3Snapshot(*L3Snapshot)
+}
+
+// snapshotInterval 每处理多少条指令推送一次全量快照，<=0 时只在启动/恢复后推送一次
+func WithL3Feed(feed PushL3, snapshotInterval int64) OrderBookOption {
+	return func(b *OrderBook) {
+		b.l3Feed = feed
+		b.l3SnapshotInterval = snapshotInterval
+		b.l3Handles = make(map[string]int64)
+		b.l3NeedSnapshot = true
+	}
+}
+
+func (b *OrderBook) emitL3(eventType L3EventType, handle int64, order *protocol.Order, size udecimal.Decimal, tradeId int64) {
+	b.l3Seq++
+	b.l3Pending = append(b.l3Pending, &L3Event{
+		MarketId:   b.marketId,
+		Seq:        b.l3Seq,
+		CmdSeqId:   b.curCmdSeqId,
+		LocalSeqId: b.curLocalSeqId,
+		Type:       eventType,
+		Handle:     handle,
+		Side:       order.Side,
+		Price:      order.Price.String(),
+		Size:       size.String(),
+		TradeId:    tradeId,
+	})
+}
+
+// 订单进入队列，分配新句柄
+func (b *OrderBook) l3Add(order *protocol.Order) {
+	if b.l3Feed == nil {
+		return
+	}
+	b.l3HandleSeq++
+	b.l3Handles[order.Id] = b.l3HandleSeq
+	b.emitL3(L3Add, b.l3HandleSeq, order, order.Size, 0)
+}
+
+func (b *OrderBook) l3Reduce(order *protocol.Order, delta udecimal.Decimal) {
+	if b.l3Feed == nil {
+		return
+	}
+	b.emitL3(L3Reduce, b.l3Handles[order.Id], order, delta, 0)
+}
+
+// 挂单成交，done 表示可见部分已全部成交并移出队列
+func (b *OrderBook) l3Execute(order *protocol.Order, size udecimal.Decimal, tradeId int64, done bool) {
+	if b.l3Feed == nil {
+		return
+	}
+	b.emitL3(L3Execute, b.l3Handles[order.Id], order, size, tradeId)
+	if done {
+		delete(b.l3Handles, order.Id)
+	}
+}
+
+func (b *OrderBook) l3Delete(order *protocol.Order) {
+	if b.l3Feed == nil {
+		return
+	}
+	b.emitL3(L3Delete, b.l3Handles[order.Id], order, order.Size, 0)
+	delete(b.l3Handles, order.Id)
+}
+
+// 指令结束后推送事件，按间隔附带全量快照
+func (b *OrderBook) flushL3() {
+	if b.l3Feed == nil {
+		return
+	}
+	if len(b.l3Pending) > 0 {
+		if !b.replaying {
+			b.l3Feed.PublishL3(b.l3Pending)
+		}
+		b.l3Pending = nil
+	}
+	b.l3CmdCount++
+	if b.l3SnapshotInterval > 0 && b.l3CmdCount%b.l3SnapshotInterval == 0 {
+		b.l3NeedSnapshot = true
+	}
+	if b.l3NeedSnapshot && !b.replaying {
+		b.l3NeedSnapshot = false
+		b.l3Feed.PublishL3Snapshot(b.l3Snapshot())
+	}
+}
+
+func (b *OrderBook) l3Snapshot() *L3Snapshot {
+	return &L3Snapshot{
+		MarketId: b.marketId,
+		Seq:      b.l3Seq,
+		Bids:     b.l3Orders(b.bidQueue),
+		Asks:     b.l3Orders(b.askQueue),
+	}
+}
+
+func (b *OrderBook) l3Orders(q *queue) []*L3Order {
+	orders := q.GetSnapshot()
+	result := make([]*L3Order, 0, len(orders))
+	for _, order := range orders {
+		result = append(result, &L3Order{
+			Handle: b.l3Handles[order.Id],
+			Price:  order.Price.String(),
+			Size:   order.Size.String(),
+		})
+	}
+	return result
+}
+
+// 按订单ID排序，保证快照内容确定
+func (b *OrderBook) l3HandleSnapshot() []L3HandleSnapshot {
+	result := make([]L3HandleSnapshot, 0, len(b.l3Handles))
+	for orderId, handle := range b.l3Handles {
+		result = append(result, L3HandleSnapshot{OrderId: orderId, Handle: handle})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].OrderId < result[j].OrderId
+	})
+	return result
+}
+
+// 恢复 L3 序号和句柄，序号接续快照继续递增；快照中没有句柄的挂单分配新句柄
+func (b *OrderBook) restoreL3(snapshot *BookSnapshot) {
+	b.l3Seq = snapshot.L3Seq
+	b.l3HandleSeq = snapshot.L3HandleSeq
+	if b.l3Feed == nil {
+		return
+	}
+	b.l3Handles = make(map[string]int64, len(snapshot.L3Handles))
+	for _, h := range snapshot.L3Handles {
+		b.l3Handles[h.OrderId] = h.Handle
+	}
+	for _, q := range []*queue{b.bidQueue, b.askQueue} {
+		for _, order := range q.GetSnapshot() {
+			if _, ok := b.l3Handles[order.Id]; !ok {
+				b.l3HandleSeq++
+				b.l3Handles[order.Id] = b.l3HandleSeq
+			}
+		}
+	}
+	b.l3NeedSnapshot = true
+}
+
+// 下游 L3 订单簿，按事件重建并用快照自检
+type L3Book struct {
+	seq    int64
+	orders map[int64]*l3BookOrder
+	levels map[protocol.Side][]int64 //按到达顺序的句柄，用于校验优先级
+}
+
+type l3BookOrder struct {
+	side  protocol.Side
+	price udecimal.Decimal
+	size  udecimal.Decimal
+}
+
+// 从快照初始化，价格或数量无法解析时返回错误
+func NewL3Book(snapshot *L3Snapshot) (*L3Book, error) {
+	book := &L3Book{
+		seq:    snapshot.Seq,
+		orders: make(map[int64]*l3BookOrder),
+		levels: make(map[protocol.Side][]int64),
+	}
+	for _, side := range []protocol.Side{protocol.Buy, protocol.Sell} {
+		orders := snapshot.Bids
+		if side == protocol.Sell {
+			orders = snapshot.Asks
+		}
+		for _, o := range orders {
+			price, size, err := parseL3Order(o)
+			if err != nil {
+				return nil, err
+			}
+			book.orders[o.Handle] = &l3BookOrder{side: side, price: price, size: size}
+			book.levels[side] = append(book.levels[side], o.Handle)
+		}
+	}
+	return book, nil
+}
+
+func parseL3Order(o *L3Order) (udecimal.Decimal, udecimal.Decimal, error) {
+	price, err := udecimal.Parse(o.Price)
+	if err != nil {
+		return udecimal.Zero, udecimal.Zero, fmt.Errorf("l3 handle %d price: %w", o.Handle, err)
+	}
+	size, err := udecimal.Parse(o.Size)
+	if err != nil {
+		return udecimal.Zero, udecimal.Zero, fmt.Errorf("l3 handle %d size: %w", o.Handle, err)
+	}
+	return price, size, nil
+}
+
+func (lb *L3Book) Seq() int64 {
+	return lb.seq
+}
+
+// 应用事件，序号回退、不连续或句柄未知时返回错误，订阅方需用快照重新同步
+func (lb *L3Book) Apply(events []*L3Event) error {
+	for _, ev := range events {
+		if ev.Seq <= lb.seq {
+			return fmt.Errorf("l3 seq regression: book %d, got %d", lb.seq, ev.Seq)
+		}
+		if ev.Seq != lb.seq+1 {
+			return fmt.Errorf("l3 seq gap: expected %d, got %d", lb.seq+1, ev.Seq)
+		}
+		lb.seq = ev.Seq
+		size, err := udecimal.Parse(ev.Size)
+		if err != nil {
+			return err
+		}
+		if ev.Type == L3Add {
+			price, err := udecimal.Parse(ev.Price)
+			if err != nil {
+				return err
+			}
+			lb.orders[ev.Handle] = &l3BookOrder{side: ev.Side, price: price, size: size}
+			lb.levels[ev.Side] = append(lb.levels[ev.Side], ev.Handle)
+			continue
+		}
+		order, ok := lb.orders[ev.Handle]
+		if !ok {
+			return fmt.Errorf("l3 unknown handle %d at seq %d", ev.Handle, ev.Seq)
+		}
+		switch ev.Type {
+		case L3Reduce, L3Execute:
+			order.size = order.size.Sub(size)
+			if order.size.LessThanOrEqual(udecimal.Zero) {
+				lb.remove(ev.Handle)
+			}
+		case L3Delete:
+			lb.remove(ev.Handle)
+		}
+	}
+	return nil
+}
+
+func (lb *L3Book) remove(handle int64) {
+	order := lb.orders[handle]
+	delete(lb.orders, handle)
+	handles := lb.levels[order.side]
+	for i, h := range handles {
+		if h == handle {
+			lb.levels[order.side] = append(handles[:i], handles[i+1:]...)
+			break
+		}
+	}
+}
+
+// 与全量快照比对，校验订单、数量和同价位优先级
+func (lb *L3Book) Validate(snapshot *L3Snapshot) error {
+	if snapshot.Seq != lb.seq {
+		return fmt.Errorf("l3 validate seq mismatch: book %d, snapshot %d", lb.seq, snapshot.Seq)
+	}
+	if len(snapshot.Bids)+len(snapshot.Asks) != len(lb.orders) {
+		return fmt.Errorf("l3 validate order count mismatch: book %d, snapshot %d", len(lb.orders), len(snapshot.Bids)+len(snapshot.Asks))
+	}
+	for side, orders := range map[protocol.Side][]*L3Order{protocol.Buy: snapshot.Bids, protocol.Sell: snapshot.Asks} {
+		//同价位内的先后顺序必须与本地到达顺序一致
+		arrival := make(map[int64]int, len(lb.levels[side]))
+		for i, h := range lb.levels[side] {
+			arrival[h] = i
+		}
+		lastIndex := make(map[string]int)
+		for _, o := range orders {
+			order, ok := lb.orders[o.Handle]
+			if !ok || order.side != side {
+				return fmt.Errorf("l3 validate missing handle %d", o.Handle)
+			}
+			price, size, err := parseL3Order(o)
+			if err != nil {
+				return err
+			}
+			if !order.price.Equal(price) || !order.size.Equal(size) {
+				return fmt.Errorf("l3 validate handle %d mismatch", o.Handle)
+			}
+			if last, ok := lastIndex[o.Price]; ok && arrival[o.Handle] < last {
+				return fmt.Errorf("l3 validate priority mismatch at price %s", o.Price)
+			}
+			lastIndex[o.Price] = arrival[o.Handle]
+		}
+	}
+	return nil
+}
+
+// 内存 L3 订阅，测试用
+type MemoryL3 struct {
+	mu        sync.Mutex
+	Events    []*L3Event
+	Snapshots []*L3Snapshot
+}
+
+func NewMemoryL3() *MemoryL3 {
+	return &MemoryL3{}
+}
+
+func (ml *MemoryL3) PublishL3(events []*L3Event) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.Events = append(ml.Events, events...)
+}
+
+func (ml *MemoryL3) PublishL3Snapshot(snapshot *L3Snapshot) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.Snapshots = append(ml.Snapshots, snapshot)
+}
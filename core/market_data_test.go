@@ -0,0 +1,169 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 按增量重建深度
+func applyDepthUpdates(levels map[protocol.Side]map[string]string, updates []*UpdateEvent) {
+	for _, u := range updates {
+		if u.Size == "0" {
+			delete(levels[u.Side], u.Price)
+			continue
+		}
+		levels[u.Side][u.Price] = u.Size
+	}
+}
+
+func assertDepthEqual(t *testing.T, q *queue, levels map[string]string, msg string) {
+	t.Helper()
+	depth := q.GetDepth(int32(q.OrderDepth()) + 1)
+	if len(depth) != len(levels) {
+		t.Fatalf("%s: expected %d levels, got %d", msg, len(depth), len(levels))
+	}
+	for _, d := range depth {
+		size, ok := levels[d.Price.String()]
+		if !ok {
+			t.Fatalf("%s: missing level %s", msg, d.Price)
+		}
+		assertDecimal(t, d.Size, udecimal.MustParse(size), msg+" level "+d.Price.String())
+	}
+}
+
+// 快照 + 增量可以重建 GetDepth
+func TestOrderBook_DepthFeed(t *testing.T) {
+	feed := NewMemoryDepth()
+	b := NewOrderBook("BTC-USDT", NewMemoryLog(), WithDepthFeed(feed))
+	cmds := randomCommands(b, 400, 3)
+	for _, cmd := range cmds[:100] {
+		b.OnEvent(&protocol.InputEvent{Cmd: cmd})
+	}
+	//订阅方先取深度快照
+	levels := map[protocol.Side]map[string]string{protocol.Buy: {}, protocol.Sell: {}}
+	depth, _ := b.GetDepth(context.Background(), &protocol.RequestGetDepth{Limit: 1000})
+	for _, d := range depth.Bids {
+		levels[protocol.Buy][d.Price.String()] = d.Size.String()
+	}
+	for _, d := range depth.Asks {
+		levels[protocol.Sell][d.Price.String()] = d.Size.String()
+	}
+	for _, cmd := range cmds[100:] {
+		b.OnEvent(&protocol.InputEvent{Cmd: cmd})
+	}
+
+	var deltas []*UpdateEvent
+	for _, u := range feed.GetUpdates() {
+		if u.CmdSeqId > depth.LastCmdSeqId || (u.CmdSeqId == 0 && u.LocalSeqId > depth.LastLocalSeqId) {
+			deltas = append(deltas, u)
+		}
+	}
+	if len(deltas) == 0 {
+		t.Fatal("expected depth updates")
+	}
+	applyDepthUpdates(levels, deltas)
+	assertDepthEqual(t, b.bidQueue, levels[protocol.Buy], "bids")
+	assertDepthEqual(t, b.askQueue, levels[protocol.Sell], "asks")
+}
+
+// 同一指令内同一档位只推送最终状态
+func TestOrderBook_DepthFeedCoalesce(t *testing.T) {
+	feed := NewMemoryDepth()
+	b := NewOrderBook("BTC-USDT", NewMemoryLog(), WithDepthFeed(feed))
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "5")
+	placeLimit(t, b, "b1", 2, protocol.Buy, "100", "2")
+
+	updates := feed.GetUpdates()
+	assertInt64(t, 2, int64(len(updates)), "update count")
+	assertString(t, "5", updates[0].Size, "open level")
+	assertString(t, "3", updates[1].Size, "partially filled level")
+	assertInt64(t, 1, updates[1].Count, "order count")
+}
+
+// 下游按 L3 事件重建的订单簿与周期性全量快照一致
+func TestOrderBook_L3Feed(t *testing.T) {
+	feed := NewMemoryL3()
+	b := NewOrderBook("BTC-USDT", NewMemoryLog(), WithL3Feed(feed, 50))
+	for _, cmd := range randomCommands(b, 600, 5) {
+		b.OnEvent(&protocol.InputEvent{Cmd: cmd})
+	}
+	if len(feed.Snapshots) < 10 {
+		t.Fatalf("expected periodic snapshots, got %d", len(feed.Snapshots))
+	}
+	book, err := NewL3Book(feed.Snapshots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := 0
+	for next < len(feed.Events) && feed.Events[next].Seq <= book.Seq() {
+		next++
+	}
+	for _, snapshot := range feed.Snapshots[1:] {
+		prev := next
+		for next < len(feed.Events) && feed.Events[next].Seq <= snapshot.Seq {
+			next++
+		}
+		if err := book.Apply(feed.Events[prev:next]); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if err := book.Validate(snapshot); err != nil {
+			t.Fatalf("validate at seq %d: %v", snapshot.Seq, err)
+		}
+	}
+	for _, ev := range feed.Events {
+		if ev.Type == L3Execute && ev.TradeId == 0 {
+			t.Fatalf("execute event without trade id at seq %d", ev.Seq)
+		}
+	}
+}
+
+// 恢复后 L3 序号和句柄接续，下游订单簿可继续应用事件；序号回退时报错
+func TestOrderBook_L3Recover(t *testing.T) {
+	feed := NewMemoryL3()
+	b := NewOrderBook("BTC-USDT", NewMemoryLog(), WithL3Feed(feed, 0))
+	placeLimit(t, b, "b1", 1, protocol.Buy, "100", "1")
+	placeLimit(t, b, "b2", 2, protocol.Buy, "99", "1")
+	book, err := NewL3Book(feed.Snapshots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*L3Event
+	for _, ev := range feed.Events {
+		if ev.Seq > book.Seq() {
+			events = append(events, ev)
+		}
+	}
+	if err := book.Apply(events); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restoredFeed := NewMemoryL3()
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog(), WithL3Feed(restoredFeed, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt64(t, b.l3Seq, restored.l3Seq, "l3 seq continues")
+	assertInt64(t, b.l3Handles["b1"], restored.l3Handles["b1"], "handle kept")
+	placeLimit(t, restored, "s1", 3, protocol.Sell, "100", "1")
+	if err := book.Apply(restoredFeed.Events); err != nil {
+		t.Fatalf("apply after restore: %v", err)
+	}
+	if err := book.Validate(restoredFeed.Snapshots[0]); err != nil {
+		t.Fatalf("validate after restore: %v", err)
+	}
+
+	if err := book.Apply(restoredFeed.Events); err == nil {
+		t.Fatal("expected seq regression error")
+	}
+	if _, err := NewL3Book(&L3Snapshot{Bids: []*L3Order{{Handle: 1, Price: "x", Size: "1"}}}); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
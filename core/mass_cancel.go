@@ -0,0 +1,101 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"sort"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 批量撤单，止损单按触发价过滤价格区间
+func (b *OrderBook) handleMassCancel(bean *protocol.MassCancelCommand) {
+	if b.collector != nil {
+		b.collector.batch = true
+	}
+	if !bean.All && bean.UserId == 0 || bean.Side > protocol.Sell {
+		b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPayload, nil)
+		return
+	}
+	var lower, upper udecimal.Decimal
+	var err error
+	if len(bean.MinPrice) > 0 {
+		if lower, err = udecimal.Parse(bean.MinPrice); err != nil || lower.IsNeg() {
+			b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPrice, nil)
+			return
+		}
+	}
+	if len(bean.MaxPrice) > 0 {
+		if upper, err = udecimal.Parse(bean.MaxPrice); err != nil || upper.IsNeg() || upper.LessThan(lower) {
+			b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPrice, nil)
+			return
+		}
+	}
+	match := func(order *protocol.Order) bool {
+		if bean.Side != 0 && order.Side != bean.Side {
+			return false
+		}
+		price := order.Price
+		if isStopOrder(order.OrderType) {
+			price = order.StopPrice
+		}
+		return (lower.IsZero() || price.GreaterThanOrEqual(lower)) && (upper.IsZero() || price.LessThanOrEqual(upper))
+	}
+	logs := acquireLogSlice()
+	b.cancelOrders(b.collectOrders(bean.UserId, bean.All, match), 0, bean.Timestamp, logs)
+	//同组订单的联动撤单在推送前处理
+	b.publishLogs(logs)
+}
+
+// 收集用户(all 为 true 时全部用户)满足条件的订单：挂单、未触发的止损单和未激活的子单
+// 按订单ID排序，保证重放时日志顺序一致
+func (b *OrderBook) collectOrders(userId int64, all bool, match func(*protocol.Order) bool) []*protocol.Order {
+	accept := func(order *protocol.Order) bool {
+		return (all || order.UserId == userId) && match(order)
+	}
+	targets := make([]*protocol.Order, 0)
+	for _, q := range []*queue{b.bidQueue, b.askQueue} {
+		orders := q.orders
+		if !all {
+			orders = q.UserOrders(userId)
+		}
+		for _, order := range orders {
+			if accept(order) {
+				targets = append(targets, order)
+			}
+		}
+	}
+	for _, order := range b.triggers.orders {
+		if accept(order) {
+			targets = append(targets, order)
+		}
+	}
+	for _, g := range b.groups.groups {
+		for _, order := range g.pending {
+			if accept(order) {
+				targets = append(targets, order)
+			}
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Id < targets[j].Id
+	})
+	return targets
+}
+
+// 逐个撤销 collectOrders 收集的订单，每个订单一条撤单日志
+func (b *OrderBook) cancelOrders(targets []*protocol.Order, reason int32, timestamp int64, logs *[]*OrderBookLog) {
+	for _, order := range targets {
+		if exist, q := b.findOrder(order.Id); exist == order {
+			b.cancelResting(order, q, reason, timestamp, logs)
+			continue
+		}
+		if b.triggers.get(order.Id) == order {
+			b.cancelTrigger(order, reason, timestamp, logs)
+			continue
+		}
+		g := b.groups.orders[order.Id]
+		b.groups.removePending(g, order)
+		b.groups.cleanup(g)
+		b.cancelPending(order, reason, timestamp, logs)
+	}
+}
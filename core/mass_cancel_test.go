@@ -0,0 +1,77 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"context"
+	"testing"
+	"time"
+)
+
+// 按用户、方向、价格区间批量撤单，每个订单一条撤单日志
+func TestOrderBook_MassCancel(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	tradeAt(t, b, "t0", "100", 1)
+	placeLimit(t, b, "b1", 7, protocol.Buy, "98", "1")
+	placeLimit(t, b, "b2", 7, protocol.Buy, "95", "1")
+	placeLimit(t, b, "a1", 7, protocol.Sell, "105", "1")
+	placeLimit(t, b, "a2", 8, protocol.Sell, "106", "1")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "st1", Side: protocol.Sell, OrderType: protocol.TypeStopMarket, StopPrice: "97", Price: "0", Size: "1", UserId: 7,
+	})
+
+	before := len(memLog.GetLogs())
+	sendCmd(t, b, protocol.CmdMassCancel, &protocol.MassCancelCommand{UserId: 7, Side: protocol.Buy, MinPrice: "96"})
+	logs := memLog.GetLogs()[before:]
+	if len(logs) != 1 || logs[0].Type != protocol.LogTypeCancel {
+		t.Fatalf("expected one cancel log, got %d", len(logs))
+	}
+	assertString(t, "b1", logs[0].OrderId, "price filtered")
+
+	before = len(memLog.GetLogs())
+	sendCmd(t, b, protocol.CmdMassCancel, &protocol.MassCancelCommand{UserId: 7})
+	logs = memLog.GetLogs()[before:]
+	if len(logs) != 3 {
+		t.Fatalf("expected 3 cancel logs, got %d", len(logs))
+	}
+	for i, id := range []string{"a1", "b2", "st1"} {
+		assertString(t, id, logs[i].OrderId, "cancel in id order")
+	}
+	assertInt64(t, 0, b.triggers.count(), "stop cancelled")
+	assertInt64(t, 1, b.askQueue.OrderCount(), "other user untouched")
+
+	sendCmd(t, b, protocol.CmdMassCancel, &protocol.MassCancelCommand{MinPrice: "10", MaxPrice: "5", All: true})
+	assertInt64(t, protocol.ReasonInvalidPrice, int64(lastLog(memLog).RejectReason), "invalid range")
+	sendCmd(t, b, protocol.CmdMassCancel, &protocol.MassCancelCommand{})
+	assertInt64(t, protocol.ReasonInvalidPayload, int64(lastLog(memLog).RejectReason), "missing user")
+}
+
+// 管理员撤销全部订单，结果汇总撤单数量
+func TestOrderBook_MassCancelAll(t *testing.T) {
+	b, _ := newTestOrderBook()
+	placeLimit(t, b, "b1", 7, protocol.Buy, "98", "1")
+	placeLimit(t, b, "a1", 8, protocol.Sell, "105", "2")
+	placeInGroup(t, b, "entry", "g1", protocol.GroupEntry, protocol.Buy, protocol.TypeLimit, "", "90", "1")
+	placeInGroup(t, b, "tp", "g1", protocol.GroupExit, protocol.Sell, protocol.TypeLimit, "", "110", "1")
+	b.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		b.Shutdown(ctx)
+	}()
+
+	future, err := b.MassCancelAsync(&protocol.MassCancelCommand{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := future.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBool(t, true, result.Accepted, "accepted")
+	assertInt64(t, 4, result.CancelledCount, "cancelled count")
+	assertString(t, "4", result.CancelledSize, "cancelled size")
+	assertInt64(t, 0, b.bidQueue.OrderCount()+b.askQueue.OrderCount(), "book empty")
+	assertInt64(t, 0, int64(len(b.groups.groups)), "groups cleared")
+}
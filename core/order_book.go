@@ -2,6 +2,7 @@ package core
 
 import (
 	"MOMEngine/protocol"
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -31,6 +32,13 @@ var cancelOrderCmdPool = sync.Pool{
 	},
 }
 
+// 改单指令缓存
+var amendOrderCmdPool = sync.Pool{
+	New: func() any {
+		return &protocol.AmendOrderCommand{}
+	},
+}
+
 // get order from pool
 func getOrderFromPool() *protocol.Order {
 	return orderPool.Get().(*protocol.Order)
@@ -50,8 +58,10 @@ type OrderBook struct {
 	lowSize           udecimal.Decimal //交易对最低交易单位
 	seqId             atomic.Int64     //全局ID
 	lastCmdSeqId      atomic.Int64     //最后一次处理指令ID
+	lastLocalSeqId    int64            //最后一次处理的本地编号指令ID
 	tradeId           atomic.Int64     //交易ID
 	shutDown          atomic.Bool
+	running           atomic.Bool //消费协程是否在运行
 	state             protocol.OrderBookState
 	bidQueue          *queue //买单队列
 	askQueue          *queue //卖单队列
@@ -60,15 +70,76 @@ type OrderBook struct {
 	shutdownCompleted chan struct{}
 	serializer        protocol.Serializer
 	traderLog         PushLog
+	ownerLog          PushLog          //订单所有者私有推送，含剩余总量
+	journal           Journal          //指令日志，为空时不落盘
+	pendingAcks       []pendingAck     //等待本批刷盘后回调的指令结果
+	replaying         bool             //重放日志中，只推送 publishedSeqId 之后的日志
+	publishedSeqId    int64            //恢复前下游已收到的最大日志ID
+	checkSeqGap       bool             //指令ID不连续时拒绝
+	stpMode           protocol.STPMode //默认自成交防护模式
+	config            MarketConfig     //下单规则
+	curCmdSeqId       int64            //当前处理的指令ID
+	curLocalSeqId     int64            //当前处理的本地编号指令ID
+	collector         *resultCollector
+	depthFeed         PushDepth //L2 增量行情
+	depthDirty        []depthKey
+	depthSeen         map[depthKey]struct{}
+	//L3 逐笔行情
+	l3Feed             PushL3
+	l3SnapshotInterval int64
+	l3Seq              int64
+	l3HandleSeq        int64
+	l3Handles          map[string]int64 //订单ID -> 匿名句柄
+	l3Pending          []*L3Event
+	l3CmdCount         int64
+	l3NeedSnapshot     bool
+	ticker             tickerStats //24小时行情统计
+	breaker            circuitBreaker
+	triggers           *triggerBook         //未触发的止损单
+	expiries           expiryIndex          //GTD/DAY 订单到期索引
+	groups             *groupRegistry       //OCO、括号单
+	heartbeats         map[sessionKey]int64 //心跳会话 -> 撤单截止时间
+	heartbeatTimeout   int64                //默认心跳超时(秒)
+	lastTimerTime      int64                //最后一次定时指令时间，心跳截止时间的起点
+	curSession         string               //当前指令的会话ID
 }
 type OrderBookOption func(*OrderBook)
 
+var ErrOrderBookShutdown = errors.New("order book is shutting down")
+
+// 开启指令ID连续性检查，只适用于每个交易对独立编号的上游
+func WithSeqGapCheck() OrderBookOption {
+	return func(b *OrderBook) {
+		b.checkSeqGap = true
+	}
+}
+
+// 设置最小交易单位
+func WithLowSize(lowSize udecimal.Decimal) OrderBookOption {
+	return func(b *OrderBook) {
+		if lowSize.GreaterThan(udecimal.Zero) {
+			b.lowSize = lowSize
+		}
+	}
+}
+
+// 设置订单所有者私有推送，日志保留 LeftSize、MakerLeftSize，公共推送中这两个字段被清空
+func WithOwnerLog(ownerLog PushLog) OrderBookOption {
+	return func(b *OrderBook) {
+		b.ownerLog = ownerLog
+	}
+}
+
 func NewOrderBook(marketId string, tradeLog PushLog, opts ...OrderBookOption) *OrderBook {
 	book := &OrderBook{
 		marketId:          marketId,
 		lowSize:           DefaultLotSize,
+		config:            DefaultMarketConfig(),
 		bidQueue:          NewBuyerQueue(),
 		askQueue:          NewSellerQueue(),
+		triggers:          newTriggerBook(),
+		groups:            newGroupRegistry(),
+		heartbeats:        make(map[sessionKey]int64),
 		done:              make(chan struct{}),
 		shutdownCompleted: make(chan struct{}),
 		traderLog:         tradeLog,
@@ -77,35 +148,176 @@ func NewOrderBook(marketId string, tradeLog PushLog, opts ...OrderBookOption) *O
 	for _, opt := range opts {
 		opt(book)
 	}
-	book.cmdBuffer = NewRingBuffer[protocol.InputEvent](60000, book)
+	book.bindDepthListener()
+	book.cmdBuffer = NewRingBuffer[protocol.InputEvent](65536, book)
 	book.state = protocol.OrderBookRunning
 	return book
 }
 
-// process event
-func (b *OrderBook) OnEvent(e *protocol.InputEvent) {
-	if e.Cmd != nil {
-		b.processCmd(e.Cmd)
-		return
+func (b *OrderBook) MarketId() string {
+	return b.marketId
+}
+
+// 启动消费协程
+func (b *OrderBook) Start() {
+	b.running.Store(true)
+	b.cmdBuffer.Start()
+}
+
+// 停止接收新指令，等待队列中剩余指令处理完毕
+func (b *OrderBook) Shutdown(ctx context.Context) error {
+	b.shutDown.Store(true)
+	if err := b.cmdBuffer.Shutdown(ctx); err != nil {
+		return err
 	}
+	b.running.Store(false)
+	return nil
 }
 
-// 避免调用push拷贝一次对象
-func (b *OrderBook) EnqueueCommand(cmd *protocol.Command) error {
+// 在撮合线程执行 fn 并等待完成，消费协程未运行时直接在当前协程执行
+// 不能在撮合线程内(如 sink 回调)调用，ctx 结束时不再等待，fn 仍可能稍后执行
+func (b *OrderBook) runInLoop(ctx context.Context, fn func()) error {
 	if b.shutDown.Load() {
-		return errors.New("order book is shutting down")
+		return ErrOrderBookShutdown
+	}
+	if !b.running.Load() {
+		fn()
+		return nil
 	}
+	done := make(chan struct{})
 	seq, slot := b.cmdBuffer.NextSeq()
 	if seq == protocol.NullIndex {
 		return errors.New("no slot")
 	}
-	slot.Cmd = cmd
+	*slot = protocol.InputEvent{Task: func() {
+		fn()
+		close(done)
+	}}
 	b.cmdBuffer.Commit(seq)
-	return nil
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// process event
+func (b *OrderBook) OnEvent(e *protocol.InputEvent) {
+	if e.Cmd != nil {
+		cmd := e.Cmd
+		if e.Ack != nil {
+			b.collector = newResultCollector(cmd.SeqId)
+			defer b.ackCmd(e.Ack, cmd)
+		}
+		//未指定全局ID的指令单独按本地顺序编号，不占用上游ID，也不参与去重和连续性检查
+		if cmd.SeqId == 0 {
+			cmd.LocalSeqId = b.lastLocalSeqId + 1
+			b.curCmdSeqId = 0
+			b.curLocalSeqId = cmd.LocalSeqId
+			if !b.appendJournal(cmd) {
+				return
+			}
+			b.processCmd(cmd)
+			b.lastLocalSeqId = cmd.LocalSeqId
+			return
+		}
+		lastCmdSeqId := b.lastCmdSeqId.Load()
+		b.curCmdSeqId = cmd.SeqId
+		b.curLocalSeqId = 0
+		//重复指令(上游超时重试)直接跳过，保证幂等
+		if cmd.SeqId <= lastCmdSeqId {
+			b.logIngestReject(protocol.ReasonDuplicateCommand)
+			return
+		}
+		if b.checkSeqGap && lastCmdSeqId > 0 && cmd.SeqId != lastCmdSeqId+1 {
+			b.logIngestReject(protocol.ReasonSeqGap)
+			return
+		}
+		if !b.appendJournal(cmd) {
+			return
+		}
+		b.processCmd(cmd)
+		b.lastCmdSeqId.Store(cmd.SeqId)
+		return
+	}
+	if e.Task != nil {
+		e.Task()
+	}
+}
+
+// 先写日志再撮合，写入失败时拒绝指令并停止订单簿
+func (b *OrderBook) appendJournal(cmd *protocol.Command) bool {
+	if b.journal == nil {
+		return true
+	}
+	if err := b.journal.Append(cmd); err != nil {
+		b.logIngestReject(protocol.ReasonJournalFailed)
+		b.stopOnJournalFailed()
+		return false
+	}
+	return true
+}
+
+// 指令日志不可用，停止订单簿；状态日志未写入指令日志，不占用日志ID
+func (b *OrderBook) stopOnJournalFailed() {
+	if b.state == protocol.OrderBookStop {
+		return
+	}
+	b.state = protocol.OrderBookStop
+	logs := acquireLogSlice()
+	*logs = append(*logs, NewStateLog(0, b.marketId, b.state, protocol.ReasonJournalFailed, time.Now().Unix()))
+	b.publishLogs(logs)
+}
+
+type pendingAck struct {
+	ack    func(*protocol.CommandResult)
+	result *protocol.CommandResult
+}
+
+// 回调指令结果，有指令日志时等本批刷盘后再回调
+func (b *OrderBook) ackCmd(ack func(*protocol.CommandResult), cmd *protocol.Command) {
+	result := b.collector.finish()
+	result.CmdSeqId = cmd.SeqId
+	b.collector = nil
+	if b.journal == nil {
+		ack(result)
+		return
+	}
+	b.pendingAcks = append(b.pendingAcks, pendingAck{ack: ack, result: result})
+}
+
+// 一批指令处理完毕，按策略刷盘
+func (b *OrderBook) OnBatchEnd() {
+	if b.journal == nil {
+		return
+	}
+	err := b.journal.Flush()
+	if err != nil {
+		//已撮合的指令无法保证落盘
+		b.stopOnJournalFailed()
+	}
+	for i, pending := range b.pendingAcks {
+		//未确认落盘的指令结果按日志失败返回
+		if err != nil {
+			pending.result.Accepted = false
+			pending.result.RejectReason = protocol.ReasonJournalFailed
+		}
+		pending.ack(pending.result)
+		b.pendingAcks[i] = pendingAck{}
+	}
+	b.pendingAcks = b.pendingAcks[:0]
+}
+
+// 避免调用push拷贝一次对象
+func (b *OrderBook) EnqueueCommand(cmd *protocol.Command) error {
+	return b.EnqueueCommandWithAck(cmd, nil)
 }
 
 // 集中转发处理指令
 func (b *OrderBook) processCmd(cmd *protocol.Command) {
+	defer b.flushMarketData()
+	b.curSession = cmd.Metadata[protocol.MetaSession]
 	switch cmd.Type {
 	case protocol.CmdSuspendMarket:
 		payload := &protocol.SuspendMarketCommand{}
@@ -121,6 +333,13 @@ func (b *OrderBook) processCmd(cmd *protocol.Command) {
 			return
 		}
 		b.handleResumeMarket(payload)
+	case protocol.CmdUpdateConfig:
+		payload := &protocol.UpdateConfigCommand{}
+		if err := b.serializer.Unmarshal(cmd.Payload, &payload); err != nil {
+			b.logRejectPayload("", payload.UserId, protocol.ReasonInvalidPayload, cmd.Metadata)
+			return
+		}
+		b.handleUpdateConfig(payload)
 	case protocol.CmdPlaceOrder:
 		payload := placeOrderCmdPool.Get().(*protocol.PlaceOrderCommand)
 		*payload = protocol.PlaceOrderCommand{}
@@ -129,20 +348,85 @@ func (b *OrderBook) processCmd(cmd *protocol.Command) {
 			b.logRejectPayload("", payload.UserId, protocol.ReasonInvalidPayload, cmd.Metadata)
 			return
 		}
+		b.collectOrder(payload.OrderId)
 		if b.state != protocol.OrderBookRunning {
 			placeOrderCmdPool.Put(payload)
-			b.logRejectPayload("", payload.UserId, protocol.ReasonStateHadDone, cmd.Metadata)
+			b.logRejectPayload(payload.OrderId, payload.UserId, protocol.ReasonStateHadDone, cmd.Metadata)
 			return
 		}
 		b.handlePlaceOrder(payload)
 		placeOrderCmdPool.Put(payload)
+	case protocol.CmdCancelOrder:
+		payload := cancelOrderCmdPool.Get().(*protocol.CancelOrderCommand)
+		*payload = protocol.CancelOrderCommand{}
+		if err := b.serializer.Unmarshal(cmd.Payload, &payload); err != nil {
+			cancelOrderCmdPool.Put(payload)
+			b.logRejectPayload("", payload.UserId, protocol.ReasonInvalidPayload, cmd.Metadata)
+			return
+		}
+		b.collectOrder(payload.OrderId)
+		if b.state == protocol.OrderBookStop {
+			cancelOrderCmdPool.Put(payload)
+			b.logRejectPayload(payload.OrderId, payload.UserId, protocol.ReasonStateHadDone, cmd.Metadata)
+			return
+		}
+		b.handleCancelOrder(payload)
+		cancelOrderCmdPool.Put(payload)
+	case protocol.CmdAmendOrder:
+		payload := amendOrderCmdPool.Get().(*protocol.AmendOrderCommand)
+		*payload = protocol.AmendOrderCommand{}
+		if err := b.serializer.Unmarshal(cmd.Payload, &payload); err != nil {
+			amendOrderCmdPool.Put(payload)
+			b.logRejectPayload("", payload.UserId, protocol.ReasonInvalidPayload, cmd.Metadata)
+			return
+		}
+		b.collectOrder(payload.OrderId)
+		if b.state != protocol.OrderBookRunning {
+			amendOrderCmdPool.Put(payload)
+			b.logRejectPayload(payload.OrderId, payload.UserId, protocol.ReasonStateHadDone, cmd.Metadata)
+			return
+		}
+		b.handleAmendOrder(payload)
+		amendOrderCmdPool.Put(payload)
+	case protocol.CmdMassCancel:
+		payload := &protocol.MassCancelCommand{}
+		if err := b.serializer.Unmarshal(cmd.Payload, &payload); err != nil {
+			b.logRejectPayload("", payload.UserId, protocol.ReasonInvalidPayload, cmd.Metadata)
+			return
+		}
+		if b.state == protocol.OrderBookStop {
+			b.logRejectPayload("", payload.UserId, protocol.ReasonStateHadDone, cmd.Metadata)
+			return
+		}
+		b.handleMassCancel(payload)
+	case protocol.CmdHeartbeat:
+		payload := &protocol.HeartbeatCommand{}
+		if err := b.serializer.Unmarshal(cmd.Payload, &payload); err != nil {
+			b.logRejectPayload("", payload.UserId, protocol.ReasonInvalidPayload, cmd.Metadata)
+			return
+		}
+		if b.state == protocol.OrderBookStop {
+			b.logRejectPayload("", payload.UserId, protocol.ReasonStateHadDone, cmd.Metadata)
+			return
+		}
+		b.handleHeartbeat(payload)
+	case protocol.CmdTimer:
+		payload := &protocol.TimerCommand{}
+		if err := b.serializer.Unmarshal(cmd.Payload, &payload); err != nil {
+			b.logRejectPayload("", 0, protocol.ReasonInvalidPayload, cmd.Metadata)
+			return
+		}
+		if b.state == protocol.OrderBookStop {
+			return
+		}
+		b.handleTimer(payload)
 	}
 }
 
 // 下单
 func (b *OrderBook) PlaceOrder(cmd *protocol.PlaceOrderCommand) error {
 	if b.shutDown.Load() {
-		return errors.New("order book is shutting down")
+		return ErrOrderBookShutdown
 	}
 	if len(cmd.OrderType) == 0 || len(cmd.OrderId) == 0 {
 		return errors.New("invalid order type")
@@ -162,7 +446,7 @@ func (b *OrderBook) PlaceOrder(cmd *protocol.PlaceOrderCommand) error {
 // 修改订单
 func (b *OrderBook) AmendOrder(cmd *protocol.AmendOrderCommand) error {
 	if b.shutDown.Load() {
-		return errors.New("order book is shutting down")
+		return ErrOrderBookShutdown
 	}
 	if len(cmd.OrderId) == 0 {
 		return errors.New("invalid order id")
@@ -182,7 +466,7 @@ func (b *OrderBook) AmendOrder(cmd *protocol.AmendOrderCommand) error {
 // 撤销订单
 func (b *OrderBook) CancelOrder(cmd *protocol.CancelOrderCommand) error {
 	if b.shutDown.Load() {
-		return errors.New("order book is shutting down")
+		return ErrOrderBookShutdown
 	}
 	if len(cmd.OrderId) == 0 {
 		return errors.New("invalid order id")
@@ -198,22 +482,151 @@ func (b *OrderBook) CancelOrder(cmd *protocol.CancelOrderCommand) error {
 	}
 	return b.EnqueueCommand(input)
 }
+
+// 批量撤单
+func (b *OrderBook) MassCancel(cmd *protocol.MassCancelCommand) error {
+	if b.shutDown.Load() {
+		return ErrOrderBookShutdown
+	}
+	bs, err := b.serializer.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	input := &protocol.Command{
+		MarketId: b.marketId,
+		Type:     protocol.CmdMassCancel,
+		Payload:  bs,
+	}
+	return b.EnqueueCommand(input)
+}
+
 func (b *OrderBook) logRejectPayload(orderId string, userId int64, reasonCode int32, _ map[string]string) {
 	logs := acquireLogSlice()
 	log := NewRejectLog(b.seqId.Add(1), b.marketId, orderId, userId, reasonCode, time.Now().Unix())
 	*logs = append(*logs, log)
-	b.traderLog.Publish(*logs)
-	releaseOrderBookLog(log)
+	b.publishLogs(logs)
+}
+
+// 未写入指令日志的拒绝(重复、跳号、写日志失败)重放时不会重现，不占用日志ID，SeqId 为 0
+func (b *OrderBook) logIngestReject(reasonCode int32) {
+	logs := acquireLogSlice()
+	*logs = append(*logs, NewRejectLog(0, b.marketId, "", 0, reasonCode, time.Now().Unix()))
+	b.publishLogs(logs)
+}
+
+// 生成只含一条拒绝记录的日志
+func (b *OrderBook) rejectLogs(order *protocol.Order, reasonCode int32) *[]*OrderBookLog {
+	logs := acquireLogSlice()
+	log := NewRejectLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, reasonCode, order.Timestamp)
+	log.Side = order.Side
+	log.Price = order.Price.String()
+	log.Size = order.Size.String()
+	log.OrderType = order.OrderType
+	*logs = append(*logs, log)
+	return logs
+}
+
+// 推送日志并回收，sink 返回后日志对象才放回缓存池
+func (b *OrderBook) publishLogs(logs *[]*OrderBookLog) {
+	b.settleLogs(logs)
+	for _, log := range *logs {
+		log.CmdSeqId = b.curCmdSeqId
+	}
+	if b.collector != nil {
+		b.collector.collect(*logs)
+	}
+	b.updateTicker(*logs)
+	if b.replaying {
+		b.republishLogs(*logs)
+	} else if len(*logs) > 0 {
+		if b.ownerLog != nil {
+			b.ownerLog.Publish(*logs)
+		}
+		stripOwnerFields(*logs)
+		b.traderLog.Publish(*logs)
+	}
+	for _, log := range *logs {
+		releaseOrderBookLog(log)
+	}
 	releaseLogSlice(logs)
 }
 
+// 重放时补推崩溃前未推送的日志，日志ID与原来一致，已推送过的不再重复
+func (b *OrderBook) republishLogs(logs []*OrderBookLog) {
+	missing := acquireLogSlice()
+	for _, log := range logs {
+		if log.SeqId > b.publishedSeqId {
+			*missing = append(*missing, log)
+		}
+	}
+	if len(*missing) > 0 {
+		if b.ownerLog != nil {
+			b.ownerLog.Publish(*missing)
+		}
+		stripOwnerFields(*missing)
+		b.traderLog.Publish(*missing)
+	}
+	releaseLogSlice(missing)
+}
+
+// 按日志顺序处理连带效果：熔断检查、触发止损单、调整跟踪止损、订单组联动
+// 连带产生的日志追加在末尾，继续参与处理，直到没有新的日志；熔断后不再触发止损单
+func (b *OrderBook) settleLogs(logs *[]*OrderBookLog) {
+	for i := 0; i < len(*logs); i++ {
+		log := (*logs)[i]
+		switch log.Type {
+		case protocol.LogTypeMatch:
+			price, err := udecimal.Parse(log.Price)
+			if err != nil {
+				continue
+			}
+			b.checkCircuitBreaker(price, log.Timestamp, logs)
+			if b.state == protocol.OrderBookRunning {
+				b.triggerStops(price, log.Timestamp, logs)
+			}
+			if len(b.groups.orders) == 0 {
+				continue
+			}
+			size, _ := udecimal.Parse(log.Size)
+			left, _ := udecimal.Parse(log.LeftSize)
+			makerLeft, _ := udecimal.Parse(log.MakerLeftSize)
+			b.onGroupFill(log.OrderId, size, len(log.LeftSize) > 0 && left.IsZero(), log.Timestamp, logs)
+			b.onGroupFill(log.MakerOrderId, size, makerLeft.IsZero(), log.Timestamp, logs)
+		case protocol.LogTypeCancel, protocol.LogTypeReject:
+			if len(b.groups.orders) > 0 {
+				b.onGroupClose(log.OrderId, log.Timestamp, logs)
+			}
+		}
+	}
+}
+
+// 撤销挂单并回收
+func (b *OrderBook) cancelResting(order *protocol.Order, q *queue, reason int32, timestamp int64, logs *[]*OrderBookLog) {
+	q.RemoveOrder(order.Id, order.Price)
+	b.l3Delete(order)
+	log := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size.Add(order.HiddenSize), order.OrderType, timestamp)
+	log.RejectReason = reason
+	*logs = append(*logs, log)
+	releaseOrder(order)
+}
+
+// 撤销未触发的止损单并回收
+func (b *OrderBook) cancelTrigger(order *protocol.Order, reason int32, timestamp int64, logs *[]*OrderBookLog) {
+	b.triggers.remove(order)
+	log := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, timestamp)
+	log.StopPrice = order.StopPrice.String()
+	log.RejectReason = reason
+	*logs = append(*logs, log)
+	releaseOrder(order)
+}
+
 // 暂停
 func (b *OrderBook) handleSuspendMarket(bean *protocol.SuspendMarketCommand) {
 	if b.state == protocol.OrderBookStop {
 		b.logRejectPayload("", bean.UserId, protocol.ReasonStateHadDone, nil)
 		return
 	}
-	b.state = protocol.OrderBookPause
+	b.changeState(protocol.OrderBookPause, 0)
 }
 
 // 恢复
@@ -222,7 +635,38 @@ func (b *OrderBook) handleResumeMarket(bean *protocol.ResumeMarketCommand) {
 		b.logRejectPayload("", bean.UserId, protocol.ReasonStateHadDone, nil)
 		return
 	}
-	b.state = protocol.OrderBookRunning
+	b.changeState(protocol.OrderBookRunning, 0)
+}
+
+// 更新配置
+func (b *OrderBook) handleUpdateConfig(bean *protocol.UpdateConfigCommand) {
+	if b.state == protocol.OrderBookStop {
+		b.logRejectPayload("", bean.UserId, protocol.ReasonStateHadDone, nil)
+		return
+	}
+	//先校验全部字段，任一非法时整体拒绝，不会只生效一部分
+	lowSize, stpMode := b.lowSize, b.stpMode
+	if len(bean.MinLotSize) > 0 {
+		size, err := udecimal.Parse(bean.MinLotSize)
+		if err != nil || size.LessThanOrEqual(udecimal.Zero) {
+			b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPayload, nil)
+			return
+		}
+		lowSize = size
+	}
+	if bean.STPMode != nil {
+		if *bean.STPMode > protocol.STPDecrementCancel {
+			b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPayload, nil)
+			return
+		}
+		stpMode = *bean.STPMode
+	}
+	config, err := b.config.Apply(&bean.TradingRules)
+	if err != nil {
+		b.logRejectPayload("", bean.UserId, protocol.ReasonInvalidPayload, nil)
+		return
+	}
+	b.lowSize, b.stpMode, b.config = lowSize, stpMode, config
 }
 
 // 处理下单指令
@@ -237,10 +681,72 @@ func (b *OrderBook) handlePlaceOrder(bean *protocol.PlaceOrderCommand) {
 		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidPayload, nil)
 		return
 	}
+	//限价类订单必须带正价格，价格为 0 的订单不会挂出
+	switch bean.OrderType {
+	case protocol.TypeLimit, protocol.TypeIOC, protocol.TypeFOK, protocol.TypePostOnly:
+		if price.LessThanOrEqual(udecimal.Zero) {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidPrice, nil)
+			return
+		}
+	}
 	visibleLimit, _ := udecimal.Parse(bean.VisibleLimit)
 	quoteSize, _ := udecimal.Parse(bean.QuoteSize)
+	if bean.STPMode > protocol.STPDecrementCancel {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidPayload, nil)
+		return
+	}
+	if reason := b.config.checkOrder(price, size, quoteSize); reason != 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+		return
+	}
+	//止损单在触发时检查价格带
+	if bean.OrderType != protocol.TypeMarket && !isStopOrder(bean.OrderType) {
+		if reason := b.checkPriceBand(price); reason != 0 {
+			b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+			return
+		}
+	}
+	if reason := b.config.checkSize(visibleLimit); reason == protocol.ReasonInvalidStep || reason == protocol.ReasonSizeDecimals {
+		b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+		return
+	}
+	expireAt, reason := orderExpireAt(bean)
+	if reason != 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+		return
+	}
+	var stopPrice udecimal.Decimal
+	var trail trailParams
+	if isTrailingStop(bean.OrderType) {
+		if trail, reason = b.parseTrailing(bean); reason != 0 {
+			b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+			return
+		}
+		stopPrice = trail.stop
+	} else if isStopOrder(bean.OrderType) {
+		stopPrice, err = udecimal.Parse(bean.StopPrice)
+		if err != nil || stopPrice.LessThanOrEqual(udecimal.Zero) || b.config.checkPrice(stopPrice) != 0 {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidStopPrice, nil)
+			return
+		}
+	}
+	if isStopOrder(bean.OrderType) {
+		if bean.OrderType != protocol.TypeStopLimit && (size.IsZero() || !quoteSize.IsZero()) {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidSize, nil)
+			return
+		}
+		if bean.OrderType == protocol.TypeStopLimit && price.LessThanOrEqual(udecimal.Zero) {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidPrice, nil)
+			return
+		}
+	}
+	group, reason := b.checkGroup(bean)
+	if reason != 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+		return
+	}
 	//repeat orde
-	if b.bidQueue.GetOrder(bean.OrderId) != nil || b.askQueue.GetOrder(bean.OrderId) != nil {
+	if b.isLive(bean.OrderId) {
 		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonDuplicateOrderID, nil)
 		return
 	}
@@ -255,17 +761,235 @@ func (b *OrderBook) handlePlaceOrder(bean *protocol.PlaceOrderCommand) {
 	if visibleLimit.GreaterThan(udecimal.Zero) && visibleLimit.LessThan(size) {
 		order.VisibleLimit = visibleLimit
 	}
+	order.STPMode = b.stpMode
+	if bean.STPMode != protocol.STPNone {
+		order.STPMode = bean.STPMode
+	}
+	order.ExpireAt = expireAt
+	order.Session = b.curSession
+	if isTrailingStop(order.OrderType) {
+		order.TrailAmount = trail.amount
+		order.TrailPercent = trail.percent
+		order.LimitOffset = trail.offset
+		order.TrailExtreme = b.ticker.lastPrice
+	}
+	if isStopOrder(order.OrderType) {
+		order.StopPrice = stopPrice
+	}
+	//加入订单组需在撮合之前，撮合产生的成交由 settleLogs 联动处理
+	if group != nil && !b.joinGroup(group, order, bean.GroupRole) {
+		//子单等待主单成交后激活
+		logs := acquireLogSlice()
+		log := NewOpenLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, udecimal.Zero, order.OrderType, order.Timestamp)
+		log.StopPrice = order.StopPrice.String()
+		log.LeftSize = udecimal.Zero.String()
+		*logs = append(*logs, log)
+		b.trackExpiry(order)
+		b.publishLogs(logs)
+		return
+	}
+	if isStopOrder(order.OrderType) {
+		logs := acquireLogSlice()
+		b.placeStopOrder(order, logs)
+		b.publishLogs(logs)
+		return
+	}
+	var logs *[]*OrderBookLog
 	switch order.OrderType {
 	case protocol.TypeMarket:
-		b.processMarketOrder(order, quoteSize)
-	case protocol.TypeLimit:
-		b.processLimitOrder(order)
+		logs = b.processMarketOrder(order, quoteSize)
+	case protocol.TypeLimit, protocol.TypeIOC:
+		logs = b.processLimitOrder(order)
+	case protocol.TypeFOK:
+		//先检查对手盘深度能否全部成交，否则不动订单簿直接拒绝
+		if !b.oppositeQueue(order.Side).CanFill(order.Price, order.Size, order.UserId, order.STPMode) {
+			logs = b.rejectLogs(order, protocol.ReasonFOKNotFilled)
+			break
+		}
+		logs = b.processLimitOrder(order)
+	case protocol.TypePostOnly:
+		if head := b.oppositeQueue(order.Side).PeakHeadOrder(); head != nil && b.isCrossed(order, head.Price) {
+			logs = b.rejectLogs(order, protocol.ReasonPostOnlyWouldCross)
+			break
+		}
+		logs = b.processLimitOrder(order)
 	default:
+		logs = b.rejectLogs(order, protocol.ReasonInvalidOrderType)
+	}
+	//挂单后订单归队列所有，不能回收；需在推送日志之前判断，触发止损单后可能已被成交回收
+	if exist, _ := b.findOrder(order.Id); exist != order {
+		releaseOrder(order)
+	} else {
+		b.trackExpiry(order)
+	}
+	b.publishLogs(logs)
+}
 
+// 处理撤单指令
+func (b *OrderBook) handleCancelOrder(bean *protocol.CancelOrderCommand) {
+	if stop := b.triggers.get(bean.OrderId); stop != nil {
+		if stop.UserId != bean.UserId {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonNotOrderOwner, nil)
+			return
+		}
+		b.cancelStopOrder(stop, bean.Timestamp)
+		return
 	}
+	if pending := b.groups.pendingOrder(bean.OrderId); pending != nil {
+		if pending.UserId != bean.UserId {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonNotOrderOwner, nil)
+			return
+		}
+		b.cancelPendingExit(pending, bean.Timestamp)
+		return
+	}
+	order, q := b.findOrder(bean.OrderId)
+	if order == nil {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonOrderNotFound, nil)
+		return
+	}
+	if order.UserId != bean.UserId {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonNotOrderOwner, nil)
+		return
+	}
+	if _, err := q.RemoveOrder(order.Id, order.Price); err != nil {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonOrderNotFound, nil)
+		return
+	}
+	b.l3Delete(order)
+	logs := acquireLogSlice()
+	log := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size.Add(order.HiddenSize), order.OrderType, bean.Timestamp)
+	*logs = append(*logs, log)
+	b.publishLogs(logs)
 	releaseOrder(order)
 }
 
+// 处理改单指令，同价减量保留队列位置，改价或加量重新排队
+func (b *OrderBook) handleAmendOrder(bean *protocol.AmendOrderCommand) {
+	if stop := b.triggers.get(bean.OrderId); stop != nil {
+		if stop.UserId != bean.UserId {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonNotOrderOwner, nil)
+			return
+		}
+		b.amendStopOrder(stop, bean)
+		return
+	}
+	if b.groups.pendingOrder(bean.OrderId) != nil {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonGroupPending, nil)
+		return
+	}
+	order, q := b.findOrder(bean.OrderId)
+	if order == nil {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonOrderNotFound, nil)
+		return
+	}
+	if order.UserId != bean.UserId {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonNotOrderOwner, nil)
+		return
+	}
+	//冰山单按总量(可见+隐藏)改单
+	totalSize := order.Size.Add(order.HiddenSize)
+	newPrice, newSize := order.Price, totalSize
+	if len(bean.NewPrice) > 0 {
+		price, err := udecimal.Parse(bean.NewPrice)
+		if err != nil || price.LessThanOrEqual(udecimal.Zero) {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidPrice, nil)
+			return
+		}
+		newPrice = price
+	}
+	if len(bean.NewSize) > 0 {
+		size, err := udecimal.Parse(bean.NewSize)
+		if err != nil || size.LessThan(b.lowSize) {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidSize, nil)
+			return
+		}
+		newSize = size
+	}
+	if newPrice.Equal(order.Price) && newSize.Equal(totalSize) {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonAmendNoChange, nil)
+		return
+	}
+	if reason := b.config.checkOrder(newPrice, newSize, udecimal.Zero); reason != 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+		return
+	}
+	if !newPrice.Equal(order.Price) {
+		if reason := b.checkPriceBand(newPrice); reason != 0 {
+			b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+			return
+		}
+		//只做 maker 的订单改价后与对手盘交叉时拒绝，与下单时一致
+		if order.OrderType == protocol.TypePostOnly {
+			if head := b.oppositeQueue(order.Side).PeakHeadOrder(); head != nil &&
+				((order.Side == protocol.Buy && newPrice.GreaterThanOrEqual(head.Price)) ||
+					(order.Side == protocol.Sell && newPrice.LessThanOrEqual(head.Price))) {
+				b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonPostOnlyWouldCross, nil)
+				return
+			}
+		}
+	}
+	oldPrice, oldSize := order.Price, totalSize
+	logs := acquireLogSlice()
+	if newPrice.Equal(oldPrice) && newSize.LessThan(oldSize) {
+		//同价减量，保留时间优先级，优先扣减隐藏部分
+		if newSize.GreaterThan(order.Size) {
+			order.HiddenSize = newSize.Sub(order.Size)
+		} else {
+			order.HiddenSize = udecimal.Zero
+			b.l3Reduce(order, order.Size.Sub(newSize))
+			q.UpdateOrderSize(order.Id, newSize)
+		}
+		log := NewAmendLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, newPrice, newSize, oldPrice, oldSize, order.OrderType, bean.Timestamp)
+		*logs = append(*logs, log)
+		b.publishLogs(logs)
+		return
+	}
+	//改价或加量，撤出后重新排到队尾，可能触发撮合
+	q.RemoveOrder(order.Id, order.Price)
+	b.l3Delete(order)
+	log := NewAmendLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, newPrice, newSize, oldPrice, oldSize, order.OrderType, bean.Timestamp)
+	*logs = append(*logs, log)
+	order.Price = newPrice
+	order.Size = newSize
+	order.HiddenSize = udecimal.Zero
+	order.Timestamp = bean.Timestamp
+	matchLogs := b.processLimitOrder(order)
+	*logs = append(*logs, *matchLogs...)
+	releaseLogSlice(matchLogs)
+	if exist, _ := b.findOrder(order.Id); exist != order {
+		releaseOrder(order)
+	}
+	b.publishLogs(logs)
+}
+
+// 对手盘队列
+func (b *OrderBook) oppositeQueue(side protocol.Side) *queue {
+	if side == protocol.Buy {
+		return b.askQueue
+	}
+	return b.bidQueue
+}
+
+// 订单价格是否能与对手价成交
+func (b *OrderBook) isCrossed(order *protocol.Order, price udecimal.Decimal) bool {
+	if order.Side == protocol.Buy {
+		return order.Price.GreaterThanOrEqual(price)
+	}
+	return order.Price.LessThanOrEqual(price)
+}
+
+// 根据订单ID查找挂单及其所在队列
+func (b *OrderBook) findOrder(id string) (*protocol.Order, *queue) {
+	if order := b.bidQueue.GetOrder(id); order != nil {
+		return order, b.bidQueue
+	}
+	if order := b.askQueue.GetOrder(id); order != nil {
+		return order, b.askQueue
+	}
+	return nil, nil
+}
+
 // 处理市价单，按数量或按金额
 func (b *OrderBook) processMarketOrder(order *protocol.Order, quoteSize udecimal.Decimal) *[]*OrderBookLog {
 	var targetQueue *queue
@@ -274,9 +998,22 @@ func (b *OrderBook) processMarketOrder(order *protocol.Order, quoteSize udecimal
 	} else {
 		targetQueue = b.bidQueue
 	}
+	//扫单超出价格带后停止，参考价取下单时的值
+	lower, upper, banded := b.priceBand()
 	logs := acquireLogSlice()
 	for {
 		tempOrder := targetQueue.PeakHeadOrder()
+		if tempOrder != nil && banded && outOfBand(tempOrder.Price, lower, upper) {
+			left := order.Size
+			if order.Size.IsZero() && !quoteSize.IsZero() {
+				left = quoteSize
+			}
+			log := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, left, order.OrderType, order.Timestamp)
+			log.RejectReason = protocol.ReasonPriceBand
+			log.LeftSize = udecimal.Zero.String()
+			*logs = append(*logs, log)
+			break
+		}
 		if tempOrder == nil {
 			//havent order
 			log := NewRejectLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, protocol.ReasonNoLiquidity, order.Timestamp)
@@ -297,6 +1034,19 @@ func (b *OrderBook) processMarketOrder(order *protocol.Order, quoteSize udecimal
 			//按金额 根据对手盘计算能换多少量 金额/价格
 			matchSize, _ = quoteSize.Div(tempOrder.Price)
 		}
+		if isSelfTrade(order, tempOrder) {
+			price := tempOrder.Price
+			reduced, done := b.preventSelfTrade(order, tempOrder, matchSize, targetQueue, logs)
+			if useQuote {
+				quoteSize = quoteSize.Sub(reduced.Mul(price))
+			} else {
+				order.Size = order.Size.Sub(reduced)
+			}
+			if done {
+				break
+			}
+			continue
+		}
 		if matchSize.GreaterThan(tempOrder.Size) {
 			matchSize = tempOrder.Size
 		}
@@ -320,13 +1070,21 @@ func (b *OrderBook) processMarketOrder(order *protocol.Order, quoteSize udecimal
 			quoteSize = quoteSize.Sub(matchSize.Mul(tempOrder.Price))
 		} else {
 			order.Size = order.Size.Sub(matchSize)
+			log.LeftSize = order.Size.String()
 		}
 		tempOrder = targetQueue.PopHeadOrder()
 		if matchSize.Equal(tempOrder.Size) {
-			//完全成交，冰山单？
-			b.checkIcebergOrder(order, targetQueue, logs)
+			//完全成交，冰山单补货
+			b.l3Execute(tempOrder, matchSize, log.TradeId, true)
+			tempOrder.Size = udecimal.Zero
+			log.MakerLeftSize = tempOrder.HiddenSize.String()
+			if !b.checkIcebergOrder(tempOrder, targetQueue, logs) {
+				releaseOrder(tempOrder)
+			}
 		} else {
+			b.l3Execute(tempOrder, matchSize, log.TradeId, false)
 			tempOrder.Size = tempOrder.Size.Sub(matchSize)
+			log.MakerLeftSize = tempOrder.Size.Add(tempOrder.HiddenSize).String()
 			targetQueue.PutOrder(tempOrder, true)
 		}
 		if useQuote && quoteSize.IsZero() || (!useQuote && order.Size.IsZero()) {
@@ -349,19 +1107,18 @@ func (b *OrderBook) processLimitOrder(order *protocol.Order) *[]*OrderBookLog {
 	logs := acquireLogSlice()
 	for {
 		tempOrder := targetQueue.PeakHeadOrder()
-		if tempOrder == nil {
+		if tempOrder == nil || !b.isCrossed(order, tempOrder.Price) {
 			//no target, put to order queue
-			orderQueue.PutOrder(order, false)
-			log := NewOpenLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, order.Timestamp)
-			*logs = append(*logs, log)
+			b.restOrder(order, orderQueue, logs)
 			break
 		}
-		if (order.Side == protocol.Buy && order.Price.LessThan(tempOrder.Price)) ||
-			(order.Side == protocol.Sell && order.Price.GreaterThan(tempOrder.Price)) {
-			orderQueue.PutOrder(order, false)
-			log := NewOpenLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, order.Timestamp)
-			*logs = append(*logs, log)
-			break
+		if isSelfTrade(order, tempOrder) {
+			reduced, done := b.preventSelfTrade(order, tempOrder, order.Size, targetQueue, logs)
+			order.Size = order.Size.Sub(reduced)
+			if done {
+				break
+			}
+			continue
 		}
 		tempOrder = targetQueue.PopHeadOrder()
 		if order.Size.GreaterThanOrEqual(tempOrder.Size) {
@@ -369,6 +1126,9 @@ func (b *OrderBook) processLimitOrder(order *protocol.Order) *[]*OrderBookLog {
 			log := NewMatchLog(b.seqId.Add(1), b.tradeId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.OrderType, tempOrder.Id, tempOrder.UserId, tempOrder.Price, tempOrder.Size, order.Timestamp)
 			*logs = append(*logs, log)
 			order.Size = order.Size.Sub(tempOrder.Size)
+			log.LeftSize = order.Size.String()
+			log.MakerLeftSize = tempOrder.HiddenSize.String()
+			b.l3Execute(tempOrder, tempOrder.Size, log.TradeId, true)
 			if !b.checkIcebergOrder(tempOrder, targetQueue, logs) {
 				releaseOrder(tempOrder)
 			}
@@ -379,7 +1139,10 @@ func (b *OrderBook) processLimitOrder(order *protocol.Order) *[]*OrderBookLog {
 			//not enough
 			log := NewMatchLog(b.seqId.Add(1), b.tradeId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.OrderType, tempOrder.Id, tempOrder.UserId, tempOrder.Price, order.Size, order.Timestamp)
 			*logs = append(*logs, log)
+			b.l3Execute(tempOrder, order.Size, log.TradeId, false)
 			tempOrder.Size = tempOrder.Size.Sub(order.Size)
+			log.LeftSize = udecimal.Zero.String()
+			log.MakerLeftSize = tempOrder.Size.Add(tempOrder.HiddenSize).String()
 			targetQueue.PutOrder(tempOrder, true)
 			break
 		}
@@ -387,6 +1150,27 @@ func (b *OrderBook) processLimitOrder(order *protocol.Order) *[]*OrderBookLog {
 	return logs
 }
 
+// 未成交部分挂单，IOC/FOK 剩余部分直接撤销
+func (b *OrderBook) restOrder(order *protocol.Order, orderQueue *queue, logs *[]*OrderBookLog) {
+	if order.OrderType == protocol.TypeIOC || order.OrderType == protocol.TypeFOK {
+		log := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, order.Timestamp)
+		log.RejectReason = protocol.ReasonIOCRemainder
+		*logs = append(*logs, log)
+		return
+	}
+	//冰山单只挂出可见部分，其余隐藏
+	total := order.Size.Add(order.HiddenSize)
+	if order.VisibleLimit.GreaterThan(udecimal.Zero) && total.GreaterThan(order.VisibleLimit) {
+		order.Size = order.VisibleLimit
+		order.HiddenSize = total.Sub(order.VisibleLimit)
+	}
+	orderQueue.PutOrder(order, false)
+	b.l3Add(order)
+	log := NewOpenLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, order.Timestamp)
+	log.LeftSize = total.String()
+	*logs = append(*logs, log)
+}
+
 // 检查冰山订单，如果是冰山订单补货后加入队列尾部
 func (b *OrderBook) checkIcebergOrder(order *protocol.Order, queue *queue, logs *[]*OrderBookLog) bool {
 	if order.HiddenSize.GreaterThan(udecimal.Zero) {
@@ -397,8 +1181,10 @@ func (b *OrderBook) checkIcebergOrder(order *protocol.Order, queue *queue, logs
 		order.Size = limit
 		order.HiddenSize = order.HiddenSize.Sub(limit)
 		queue.PutOrder(order, false)
+		b.l3Add(order)
 
 		log := NewOpenLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, order.Timestamp)
+		log.LeftSize = order.Size.Add(order.HiddenSize).String()
 		*logs = append(*logs, log)
 		return true
 	}
@@ -9,24 +9,29 @@ import (
 )
 
 type OrderBookLog struct {
-	SeqId        int64              `json:"seqId"`
-	TradeId      int64              `json:"tradeId"`
-	Type         protocol.LogType   `json:"type"`
-	MarketId     string             `json:"marketId"`
-	Side         protocol.Side      `json:"side"`
-	Price        string             `json:"price"`  //售价
-	Size         string             `json:"size"`   //数量
-	Amount       string             `json:"amount"` //价格
-	OrderId      string             `json:"orderId"`
-	UserId       int64              `json:"userId"`
-	OrderType    protocol.OrderType `json:"orderType"`
-	PrePrice     string             `json:"prePrice"` //just type=amend
-	PreSize      string             `json:"preSize"`
-	MakerOrderId string             `json:"makerOrderId"`
-	MakerUserId  int64              `json:"makerUserId"`
-	RejectReason int32              `json:"rejectReason"`
-	Timestamp    int64              `json:"timestamp"`
-	CreateTime   time.Time          `json:"createTime"`
+	SeqId         int64                   `json:"seqId"`
+	CmdSeqId      int64                   `json:"cmdSeqId"` //产生该日志的指令ID
+	TradeId       int64                   `json:"tradeId"`
+	Type          protocol.LogType        `json:"type"`
+	MarketId      string                  `json:"marketId"`
+	Side          protocol.Side           `json:"side"`
+	Price         string                  `json:"price"`  //售价
+	Size          string                  `json:"size"`   //数量
+	Amount        string                  `json:"amount"` //价格
+	OrderId       string                  `json:"orderId"`
+	UserId        int64                   `json:"userId"`
+	OrderType     protocol.OrderType      `json:"orderType"`
+	PrePrice      string                  `json:"prePrice"` //just type=amend
+	PreSize       string                  `json:"preSize"`
+	MakerOrderId  string                  `json:"makerOrderId"`
+	MakerUserId   int64                   `json:"makerUserId"`
+	LeftSize      string                  `json:"leftSize"`      //订单剩余总量，含冰山隐藏部分，只推送到 ownerLog
+	MakerLeftSize string                  `json:"makerLeftSize"` //挂单方剩余总量，含冰山隐藏部分，只推送到 ownerLog
+	RejectReason  int32                   `json:"rejectReason"`
+	State         protocol.OrderBookState `json:"state"`     //just type=state
+	StopPrice     string                  `json:"stopPrice"` //止损单触发价
+	Timestamp     int64                   `json:"timestamp"`
+	CreateTime    time.Time               `json:"createTime"`
 }
 
 type PushLog interface {
@@ -91,6 +96,27 @@ func (ml *MemoryLog) GetLogs() []*OrderBookLog {
 	return logs
 }
 
+// 多路推送，按顺序分发给每个订阅方
+type MultiLog []PushLog
+
+func NewMultiLog(logs ...PushLog) MultiLog {
+	return MultiLog(logs)
+}
+
+func (m MultiLog) Publish(logs []*OrderBookLog) {
+	for _, l := range m {
+		l.Publish(logs)
+	}
+}
+
+// 清除订单所有者私有字段，公共推送不暴露冰山隐藏数量
+func stripOwnerFields(logs []*OrderBookLog) {
+	for _, log := range logs {
+		log.LeftSize = ""
+		log.MakerLeftSize = ""
+	}
+}
+
 func NewOpenLog(seqId int64, marketId string, orderId string, userId int64, side protocol.Side, price udecimal.Decimal, size udecimal.Decimal, orderType protocol.OrderType, timestamp int64) *OrderBookLog {
 	log := getOrderBookLog()
 	log.SeqId = seqId
@@ -190,3 +216,16 @@ func NewRejectLog(seqID int64, marketID string, orderID string, userID int64, re
 	log.CreateTime = time.Now().UTC()
 	return log
 }
+
+// 交易对状态变更，reason 为触发原因，手动变更为 0
+func NewStateLog(seqID int64, marketID string, state protocol.OrderBookState, reason int32, timestamp int64) *OrderBookLog {
+	log := getOrderBookLog()
+	log.SeqId = seqID
+	log.Type = protocol.LogTypeState
+	log.MarketId = marketID
+	log.State = state
+	log.RejectReason = reason
+	log.Timestamp = timestamp
+	log.CreateTime = time.Now().UTC()
+	return log
+}
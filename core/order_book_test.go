@@ -0,0 +1,433 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"testing"
+)
+
+// 创建测试用订单簿，直接在当前协程调用 processCmd
+func newTestOrderBook() (*OrderBook, *MemoryLog) {
+	memLog := NewMemoryLog()
+	return NewOrderBook("BTC-USDT", memLog), memLog
+}
+
+func sendCmd(t *testing.T, b *OrderBook, cmdType protocol.CommandType, payload any) {
+	t.Helper()
+	bs, err := b.serializer.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	b.processCmd(&protocol.Command{MarketId: b.marketId, Type: cmdType, Payload: bs})
+}
+
+func placeLimit(t *testing.T, b *OrderBook, id string, userId int64, side protocol.Side, price, size string) {
+	t.Helper()
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId:   id,
+		Side:      side,
+		OrderType: protocol.TypeLimit,
+		Price:     price,
+		Size:      size,
+		UserId:    userId,
+	})
+}
+
+// 校验日志类型序列
+func assertLogTypes(t *testing.T, logs []*OrderBookLog, expected ...protocol.LogType) {
+	t.Helper()
+	if len(logs) != len(expected) {
+		t.Fatalf("log count: expected %d, got %d", len(expected), len(logs))
+	}
+	for i, log := range logs {
+		if log.Type != expected[i] {
+			t.Errorf("log[%d] type: expected %d, got %d", i, expected[i], log.Type)
+		}
+		assertInt64(t, int64(i+1), log.SeqId, "log seqId")
+	}
+}
+
+// 限价单穿价成交，剩余部分挂单
+func TestOrderBook_LimitCross(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "1")
+	placeLimit(t, b, "s2", 2, protocol.Sell, "101", "2")
+	placeLimit(t, b, "b1", 3, protocol.Buy, "101", "4")
+
+	logs := memLog.GetLogs()
+	assertLogTypes(t, logs,
+		protocol.LogTypeOpen, protocol.LogTypeOpen,
+		protocol.LogTypeMatch, protocol.LogTypeMatch, protocol.LogTypeOpen)
+
+	assertString(t, "s1", logs[2].MakerOrderId, "first match maker")
+	assertString(t, "100", logs[2].Price, "first match price")
+	assertString(t, "1", logs[2].Size, "first match size")
+	assertInt64(t, 1, logs[2].TradeId, "first tradeId")
+	assertString(t, "s2", logs[3].MakerOrderId, "second match maker")
+	assertString(t, "101", logs[3].Price, "second match price")
+	assertString(t, "2", logs[3].Size, "second match size")
+	assertString(t, "202", logs[3].Amount, "second match amount")
+	assertInt64(t, 2, logs[3].TradeId, "second tradeId")
+	assertString(t, "b1", logs[4].OrderId, "rest order")
+	assertString(t, "1", logs[4].Size, "rest size")
+
+	assertInt64(t, 0, b.askQueue.OrderCount(), "ask count")
+	assertInt64(t, 1, b.bidQueue.OrderCount(), "bid count")
+}
+
+// 限价单部分吃掉挂单，挂单保留队首位置
+func TestOrderBook_LimitPartialMaker(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "5")
+	placeLimit(t, b, "s2", 2, protocol.Sell, "100", "1")
+	placeLimit(t, b, "b1", 3, protocol.Buy, "100", "2")
+
+	logs := memLog.GetLogs()
+	assertLogTypes(t, logs, protocol.LogTypeOpen, protocol.LogTypeOpen, protocol.LogTypeMatch)
+	assertString(t, "2", logs[2].Size, "match size")
+
+	head := b.askQueue.PeakHeadOrder()
+	assertString(t, "s1", head.Id, "maker keeps priority")
+	assertString(t, "3", head.Size.String(), "maker remaining")
+}
+
+// 市价单扫多档，流动性不足时拒绝剩余部分
+func TestOrderBook_MarketSweep(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "b1", 1, protocol.Buy, "99", "1")
+	placeLimit(t, b, "b2", 2, protocol.Buy, "98", "1")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId:   "m1",
+		Side:      protocol.Sell,
+		OrderType: protocol.TypeMarket,
+		Price:     "0",
+		Size:      "3",
+		UserId:    3,
+	})
+
+	logs := memLog.GetLogs()
+	assertLogTypes(t, logs,
+		protocol.LogTypeOpen, protocol.LogTypeOpen,
+		protocol.LogTypeMatch, protocol.LogTypeMatch, protocol.LogTypeReject)
+	assertString(t, "99", logs[2].Price, "best bid first")
+	assertString(t, "98", logs[3].Price, "next bid")
+	assertInt64(t, protocol.ReasonNoLiquidity, int64(logs[4].RejectReason), "reject reason")
+	assertString(t, "1", logs[4].Size, "unfilled size")
+	assertInt64(t, 0, b.bidQueue.OrderCount(), "bid count")
+}
+
+// 市价单按金额成交
+func TestOrderBook_MarketQuoteSize(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "10", "1")
+	placeLimit(t, b, "s2", 2, protocol.Sell, "20", "5")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId:   "m1",
+		Side:      protocol.Buy,
+		OrderType: protocol.TypeMarket,
+		Price:     "0",
+		Size:      "0",
+		QuoteSize: "50",
+		UserId:    3,
+	})
+
+	logs := memLog.GetLogs()
+	assertLogTypes(t, logs, protocol.LogTypeOpen, protocol.LogTypeOpen, protocol.LogTypeMatch, protocol.LogTypeMatch)
+	assertString(t, "10", logs[2].Amount, "first amount")
+	assertString(t, "2", logs[3].Size, "second size")
+	assertString(t, "40", logs[3].Amount, "second amount")
+	assertString(t, "3", b.askQueue.PeakHeadOrder().Size.String(), "s2 remaining")
+}
+
+// 撤单与改单
+func TestOrderBook_CancelAndAmend(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "b1", 1, protocol.Buy, "100", "5")
+	placeLimit(t, b, "b2", 2, protocol.Buy, "100", "5")
+
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "b1", UserId: 2})
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "x", UserId: 1})
+	//同价减量保留优先级
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "b1", UserId: 1, NewSize: "3"})
+	assertString(t, "b1", b.bidQueue.PeakHeadOrder().Id, "reduce keeps priority")
+	//加量重新排队
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "b1", UserId: 1, NewSize: "6"})
+	assertString(t, "b2", b.bidQueue.PeakHeadOrder().Id, "increase loses priority")
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "b2", UserId: 2})
+
+	logs := memLog.GetLogs()
+	assertLogTypes(t, logs,
+		protocol.LogTypeOpen, protocol.LogTypeOpen,
+		protocol.LogTypeReject, protocol.LogTypeReject,
+		protocol.LogTypeAmend,
+		protocol.LogTypeAmend, protocol.LogTypeOpen,
+		protocol.LogTypeCancel)
+	assertInt64(t, protocol.ReasonNotOrderOwner, int64(logs[2].RejectReason), "owner reject")
+	assertInt64(t, protocol.ReasonOrderNotFound, int64(logs[3].RejectReason), "not found reject")
+	assertString(t, "5", logs[4].PreSize, "amend pre size")
+	assertString(t, "3", logs[4].Size, "amend size")
+	assertString(t, "5", logs[7].Size, "cancel size")
+	assertInt64(t, 1, b.bidQueue.OrderCount(), "bid count")
+}
+
+// 撤单回报剩余数量，价格档位清空后删除，撤过的订单再撤时拒绝
+func TestOrderBook_Cancel(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "5")
+	placeLimit(t, b, "s2", 1, protocol.Sell, "101", "1")
+	placeLimit(t, b, "b1", 2, protocol.Buy, "100", "2")
+
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "s1", UserId: 2})
+	assertInt64(t, protocol.ReasonNotOrderOwner, int64(lastLog(memLog).RejectReason), "wrong owner")
+	assertInt64(t, 2, b.askQueue.OrderCount(), "order kept after wrong owner")
+
+	before := len(memLog.GetLogs())
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "s1", UserId: 1})
+	logs := memLog.GetLogs()[before:]
+	if len(logs) != 1 || logs[0].Type != protocol.LogTypeCancel {
+		t.Fatalf("expected one cancel log, got %d", len(logs))
+	}
+	assertString(t, "s1", logs[0].OrderId, "cancelled order")
+	assertString(t, "3", logs[0].Size, "remaining after partial fill")
+	assertString(t, "100", logs[0].Price, "cancel price")
+	assertString(t, "101", b.askQueue.PeakHeadOrder().Price.String(), "empty level removed")
+	assertInt64(t, 1, b.askQueue.OrderCount(), "ask count")
+
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "s1", UserId: 1})
+	assertInt64(t, int64(protocol.LogTypeReject), int64(lastLog(memLog).Type), "cancel twice")
+	assertInt64(t, protocol.ReasonOrderNotFound, int64(lastLog(memLog).RejectReason), "not found after cancel")
+}
+
+// 改价重新排队并可能成交，改单日志带改前价格和数量，非法改单拒绝且订单不变
+func TestOrderBook_Amend(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "101", "2")
+	placeLimit(t, b, "b1", 2, protocol.Buy, "99", "3")
+	placeLimit(t, b, "b2", 3, protocol.Buy, "98", "1")
+
+	reject := func(amend *protocol.AmendOrderCommand, reason int32, msg string) {
+		t.Helper()
+		sendCmd(t, b, protocol.CmdAmendOrder, amend)
+		assertInt64(t, int64(protocol.LogTypeReject), int64(lastLog(memLog).Type), msg)
+		assertInt64(t, int64(reason), int64(lastLog(memLog).RejectReason), msg)
+	}
+	reject(&protocol.AmendOrderCommand{OrderId: "b1", UserId: 2}, protocol.ReasonAmendNoChange, "no change")
+	reject(&protocol.AmendOrderCommand{OrderId: "b1", UserId: 2, NewPrice: "99", NewSize: "3"}, protocol.ReasonAmendNoChange, "same values")
+	reject(&protocol.AmendOrderCommand{OrderId: "b1", UserId: 2, NewPrice: "0"}, protocol.ReasonInvalidPrice, "zero price")
+	reject(&protocol.AmendOrderCommand{OrderId: "b1", UserId: 2, NewPrice: "abc"}, protocol.ReasonInvalidPrice, "bad price")
+	reject(&protocol.AmendOrderCommand{OrderId: "b1", UserId: 2, NewSize: "0"}, protocol.ReasonInvalidSize, "zero size")
+	reject(&protocol.AmendOrderCommand{OrderId: "b1", UserId: 3, NewSize: "1"}, protocol.ReasonNotOrderOwner, "wrong owner")
+	reject(&protocol.AmendOrderCommand{OrderId: "x", UserId: 2, NewSize: "1"}, protocol.ReasonOrderNotFound, "unknown order")
+	order := b.bidQueue.GetOrder("b1")
+	assertString(t, "99", order.Price.String(), "price unchanged after rejects")
+	assertString(t, "3", order.Size.String(), "size unchanged after rejects")
+
+	//降价排到更低档位
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "b1", UserId: 2, NewPrice: "97"})
+	assertString(t, "b2", b.bidQueue.PeakHeadOrder().Id, "lower price loses priority")
+	assertInt64(t, int64(protocol.LogTypeOpen), int64(lastLog(memLog).Type), "re-queued")
+
+	//改价穿过对手盘时成交，剩余部分挂单
+	before := len(memLog.GetLogs())
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "b1", UserId: 2, NewPrice: "101"})
+	logs := memLog.GetLogs()[before:]
+	assertInt64(t, 3, int64(len(logs)), "amend, match, open")
+	assertInt64(t, int64(protocol.LogTypeAmend), int64(logs[0].Type), "amend first")
+	assertString(t, "97", logs[0].PrePrice, "pre price")
+	assertString(t, "3", logs[0].PreSize, "pre size")
+	assertString(t, "101", logs[0].Price, "new price")
+	assertInt64(t, int64(protocol.LogTypeMatch), int64(logs[1].Type), "crossing amend matches")
+	assertString(t, "s1", logs[1].MakerOrderId, "maker")
+	assertString(t, "2", logs[1].Size, "match size")
+	assertInt64(t, int64(protocol.LogTypeOpen), int64(logs[2].Type), "remainder rests")
+	assertString(t, "1", b.bidQueue.GetOrder("b1").Size.String(), "remaining size")
+	assertInt64(t, 0, b.askQueue.OrderCount(), "ask consumed")
+}
+
+// IOC/FOK/PostOnly
+func TestOrderBook_TimeInForceTypes(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "2")
+	place := func(id string, orderType protocol.OrderType, price, size string) {
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id, Side: protocol.Buy, OrderType: orderType, Price: price, Size: size, UserId: 2,
+		})
+	}
+	place("fok", protocol.TypeFOK, "100", "3")
+	place("post", protocol.TypePostOnly, "100", "1")
+	place("ioc", protocol.TypeIOC, "100", "3")
+
+	logs := memLog.GetLogs()
+	assertLogTypes(t, logs,
+		protocol.LogTypeOpen,
+		protocol.LogTypeReject,
+		protocol.LogTypeReject,
+		protocol.LogTypeMatch, protocol.LogTypeCancel)
+	assertInt64(t, protocol.ReasonFOKNotFilled, int64(logs[1].RejectReason), "fok reason")
+	assertInt64(t, protocol.ReasonPostOnlyWouldCross, int64(logs[2].RejectReason), "post only reason")
+	assertString(t, "1", logs[4].Size, "ioc cancelled remainder")
+	assertInt64(t, 0, b.askQueue.OrderCount()+b.bidQueue.OrderCount(), "book empty")
+}
+
+// IOC 跨档成交后撤销剩余，FOK 深度足够时跨档全部成交、不足时不动订单簿，PostOnly 不交叉时挂单
+func TestOrderBook_TimeInForceOutcomes(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "1")
+	placeLimit(t, b, "s2", 1, protocol.Sell, "101", "2")
+	placeLimit(t, b, "s3", 1, protocol.Sell, "102", "5")
+	place := func(id string, orderType protocol.OrderType, price, size string) []*OrderBookLog {
+		t.Helper()
+		before := len(memLog.GetLogs())
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id, Side: protocol.Buy, OrderType: orderType, Price: price, Size: size, UserId: 2,
+		})
+		return memLog.GetLogs()[before:]
+	}
+
+	//深度不足时整单拒绝，挂单不受影响
+	logs := place("fok1", protocol.TypeFOK, "101", "4")
+	if len(logs) != 1 || logs[0].Type != protocol.LogTypeReject {
+		t.Fatalf("fok should only reject, got %d logs", len(logs))
+	}
+	assertInt64(t, protocol.ReasonFOKNotFilled, int64(logs[0].RejectReason), "fok reason")
+	assertInt64(t, 3, b.askQueue.OrderCount(), "book untouched")
+	assertString(t, "1", b.askQueue.PeakHeadOrder().Size.String(), "head untouched")
+
+	logs = place("fok2", protocol.TypeFOK, "101", "3")
+	if len(logs) != 2 || logs[0].Type != protocol.LogTypeMatch || logs[1].Type != protocol.LogTypeMatch {
+		t.Fatalf("fok should fill across levels, got %d logs", len(logs))
+	}
+	assertString(t, "101", logs[1].Price, "second level")
+	assertInt64(t, 1, b.askQueue.OrderCount(), "two levels consumed")
+
+	logs = place("ioc1", protocol.TypeIOC, "102", "7")
+	if len(logs) != 2 || logs[0].Type != protocol.LogTypeMatch || logs[1].Type != protocol.LogTypeCancel {
+		t.Fatalf("ioc should match then cancel, got %d logs", len(logs))
+	}
+	assertString(t, "5", logs[0].Size, "ioc filled")
+	assertString(t, "2", logs[1].Size, "ioc remainder")
+	assertInt64(t, protocol.ReasonIOCRemainder, int64(logs[1].RejectReason), "ioc reason")
+
+	logs = place("ioc2", protocol.TypeIOC, "99", "1")
+	if len(logs) != 1 || logs[0].Type != protocol.LogTypeCancel {
+		t.Fatalf("ioc without liquidity should cancel, got %d logs", len(logs))
+	}
+	assertString(t, "1", logs[0].Size, "whole size cancelled")
+
+	logs = place("post", protocol.TypePostOnly, "99", "1")
+	if len(logs) != 1 || logs[0].Type != protocol.LogTypeOpen {
+		t.Fatalf("post only should rest, got %d logs", len(logs))
+	}
+	assertString(t, "post", b.bidQueue.PeakHeadOrder().Id, "post only resting")
+	assertInt64(t, 0, b.askQueue.OrderCount(), "ask empty")
+}
+
+// PostOnly 改价后会与对手盘交叉时拒绝，原订单不变
+func TestOrderBook_AmendPostOnly(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "1")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "post", Side: protocol.Buy, OrderType: protocol.TypePostOnly, Price: "99", Size: "1", UserId: 2,
+	})
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "post", UserId: 2, NewPrice: "100"})
+	assertInt64(t, protocol.ReasonPostOnlyWouldCross, int64(lastLog(memLog).RejectReason), "amend would cross")
+	order, _ := b.findOrder("post")
+	assertString(t, "99", order.Price.String(), "price unchanged")
+	assertInt64(t, 1, b.askQueue.OrderCount(), "maker untouched")
+
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "post", UserId: 2, NewPrice: "99.5"})
+	order, _ = b.findOrder("post")
+	assertString(t, "99.5", order.Price.String(), "non crossing amend")
+}
+
+// 冰山单只挂出可见部分，完全成交后在队尾补货；剩余总量只推送给订单所有者
+func TestOrderBook_Iceberg(t *testing.T) {
+	memLog, ownerLog := NewMemoryLog(), NewMemoryLog()
+	b := NewOrderBook("BTC-USDT", memLog, WithOwnerLog(ownerLog))
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "ice", Side: protocol.Sell, OrderType: protocol.TypeLimit,
+		Price: "100", Size: "10", VisibleLimit: "3", UserId: 1,
+	})
+	placeLimit(t, b, "s2", 2, protocol.Sell, "100", "1")
+
+	depth := b.askQueue.GetDepth(5)
+	assertString(t, "4", depth[0].Size.String(), "depth shows visible only")
+
+	placeLimit(t, b, "b1", 3, protocol.Buy, "100", "3")
+	assertString(t, "s2", b.askQueue.PeakHeadOrder().Id, "refilled slice goes to back")
+	depth = b.askQueue.GetDepth(5)
+	assertString(t, "4", depth[0].Size.String(), "depth after refill")
+
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "ice", UserId: 1})
+
+	for _, log := range memLog.GetLogs() {
+		if len(log.LeftSize) > 0 || len(log.MakerLeftSize) > 0 {
+			t.Fatalf("public log %d leaks left size", log.SeqId)
+		}
+	}
+	logs := ownerLog.GetLogs()
+	assertLogTypes(t, logs,
+		protocol.LogTypeOpen, protocol.LogTypeOpen,
+		protocol.LogTypeMatch, protocol.LogTypeOpen,
+		protocol.LogTypeCancel)
+	assertString(t, "3", logs[0].Size, "open visible size")
+	assertString(t, "10", logs[0].LeftSize, "open total size")
+	assertString(t, "7", logs[2].MakerLeftSize, "maker left after match")
+	assertString(t, "3", logs[3].Size, "refill size")
+	assertString(t, "7", logs[3].LeftSize, "refill total")
+	assertString(t, "7", logs[4].Size, "cancel remaining total")
+}
+
+// 重复指令跳过，开启连续性检查时跳号指令被拒绝
+func TestOrderBook_CommandSeqId(t *testing.T) {
+	memLog := NewMemoryLog()
+	b := NewOrderBook("BTC-USDT", memLog, WithSeqGapCheck())
+	send := func(seqId int64, id string) {
+		bs, _ := b.serializer.Marshal(&protocol.PlaceOrderCommand{
+			OrderId: id, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1,
+		})
+		b.OnEvent(&protocol.InputEvent{Cmd: &protocol.Command{MarketId: b.marketId, SeqId: seqId, Type: protocol.CmdPlaceOrder, Payload: bs}})
+	}
+	send(1, "o1")
+	send(1, "o1-retry")
+	send(3, "o3")
+	send(2, "o2")
+
+	//未写入指令日志的拒绝不占用日志ID
+	logs := memLog.GetLogs()
+	if len(logs) != 4 || logs[0].Type != protocol.LogTypeOpen || logs[3].Type != protocol.LogTypeOpen {
+		t.Fatalf("expected open, reject, reject, open, got %d logs", len(logs))
+	}
+	assertInt64(t, protocol.ReasonDuplicateCommand, int64(logs[1].RejectReason), "duplicate reason")
+	assertInt64(t, 1, logs[1].CmdSeqId, "duplicate cmd seq")
+	assertInt64(t, 0, logs[1].SeqId, "duplicate log seq")
+	assertInt64(t, protocol.ReasonSeqGap, int64(logs[2].RejectReason), "gap reason")
+	assertInt64(t, 3, logs[2].CmdSeqId, "gap cmd seq")
+	assertInt64(t, 0, logs[2].SeqId, "gap log seq")
+	assertInt64(t, 2, logs[3].SeqId, "log seq continues")
+	assertInt64(t, 2, b.lastCmdSeqId.Load(), "lastCmdSeqId")
+	assertInt64(t, 2, b.bidQueue.OrderCount(), "applied orders")
+}
+
+// 未指定 SeqId 的指令按本地编号，不占用上游ID
+func TestOrderBook_LocalSeqId(t *testing.T) {
+	memLog := NewMemoryLog()
+	b := NewOrderBook("BTC-USDT", memLog, WithSeqGapCheck())
+	send := func(seqId int64, cmdType protocol.CommandType, payload any) {
+		bs, _ := b.serializer.Marshal(payload)
+		b.OnEvent(&protocol.InputEvent{Cmd: &protocol.Command{MarketId: b.marketId, SeqId: seqId, Type: cmdType, Payload: bs}})
+	}
+	place := func(id string) *protocol.PlaceOrderCommand {
+		return &protocol.PlaceOrderCommand{OrderId: id, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1}
+	}
+	send(1, protocol.CmdPlaceOrder, place("o1"))
+	send(0, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 10})
+	send(0, protocol.CmdPlaceOrder, place("local"))
+	send(2, protocol.CmdPlaceOrder, place("o2"))
+
+	assertInt64(t, 3, b.bidQueue.OrderCount(), "applied orders")
+	assertInt64(t, 2, b.lastCmdSeqId.Load(), "lastCmdSeqId")
+	assertInt64(t, 2, b.lastLocalSeqId, "lastLocalSeqId")
+	logs := memLog.GetLogs()
+	assertInt64(t, 0, logs[1].CmdSeqId, "local command has no upstream seq")
+	assertInt64(t, 2, logs[2].CmdSeqId, "upstream seq")
+}
@@ -0,0 +1,354 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"sort"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 订单组，OCO 或括号单
+type orderGroup struct {
+	id          string
+	userId      int64
+	bracket     bool
+	entryId     string            //括号单主单ID
+	entryDone   bool              //主单已全部成交或撤销
+	entryFilled udecimal.Decimal  //主单累计成交量，子单按此数量激活
+	resolved    bool              //已有腿成交或撤销，其余腿已联动撤销
+	legs        []string          //已激活的腿，OCO 的各腿或括号单的子单
+	pending     []*protocol.Order //等待主单成交的子单
+}
+
+func (g *orderGroup) removeLeg(id string) {
+	for i, leg := range g.legs {
+		if leg == id {
+			g.legs = append(g.legs[:i], g.legs[i+1:]...)
+			return
+		}
+	}
+}
+
+// 订单组登记表
+type groupRegistry struct {
+	groups map[string]*orderGroup
+	orders map[string]*orderGroup //订单ID -> 所在组，包含主单、已激活和未激活的子单
+}
+
+func newGroupRegistry() *groupRegistry {
+	return &groupRegistry{
+		groups: make(map[string]*orderGroup),
+		orders: make(map[string]*orderGroup),
+	}
+}
+
+// 未激活的子单
+func (gr *groupRegistry) pendingOrder(id string) *protocol.Order {
+	g := gr.orders[id]
+	if g == nil {
+		return nil
+	}
+	for _, order := range g.pending {
+		if order.Id == id {
+			return order
+		}
+	}
+	return nil
+}
+
+func (gr *groupRegistry) removePending(g *orderGroup, order *protocol.Order) {
+	for i, o := range g.pending {
+		if o == order {
+			g.pending = append(g.pending[:i], g.pending[i+1:]...)
+			break
+		}
+	}
+	delete(gr.orders, order.Id)
+}
+
+// 没有剩余订单的组从登记表删除
+func (gr *groupRegistry) cleanup(g *orderGroup) {
+	if len(g.legs) == 0 && len(g.pending) == 0 && (!g.bracket || g.entryDone || len(g.entryId) == 0) {
+		delete(gr.groups, g.id)
+	}
+}
+
+// 按组ID排序，保证快照内容确定
+func (gr *groupRegistry) snapshot() []*GroupSnapshot {
+	result := make([]*GroupSnapshot, 0, len(gr.groups))
+	for _, g := range gr.groups {
+		result = append(result, &GroupSnapshot{
+			Id:          g.id,
+			UserId:      g.userId,
+			Bracket:     g.bracket,
+			EntryId:     g.entryId,
+			EntryDone:   g.entryDone,
+			EntryFilled: g.entryFilled,
+			Resolved:    g.resolved,
+			Legs:        append([]string(nil), g.legs...),
+			Pending:     copyOrders(g.pending),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+func (gr *groupRegistry) restore(gs *GroupSnapshot) {
+	g := &orderGroup{
+		id:          gs.Id,
+		userId:      gs.UserId,
+		bracket:     gs.Bracket,
+		entryId:     gs.EntryId,
+		entryDone:   gs.EntryDone,
+		entryFilled: gs.EntryFilled,
+		resolved:    gs.Resolved,
+		legs:        append([]string(nil), gs.Legs...),
+	}
+	if len(g.entryId) > 0 && !g.entryDone {
+		gr.orders[g.entryId] = g
+	}
+	for _, id := range g.legs {
+		gr.orders[id] = g
+	}
+	for _, od := range gs.Pending {
+		order := getOrderFromPool()
+		*order = *od
+		g.pending = append(g.pending, order)
+		gr.orders[order.Id] = g
+	}
+	gr.groups[g.id] = g
+}
+
+// 校验下单指令的订单组，返回要加入的组，新组在加入时才登记
+func (b *OrderBook) checkGroup(bean *protocol.PlaceOrderCommand) (*orderGroup, int32) {
+	if len(bean.GroupId) == 0 {
+		if len(bean.GroupRole) > 0 {
+			return nil, protocol.ReasonInvalidPayload
+		}
+		return nil, 0
+	}
+	var bracket bool
+	switch bean.GroupRole {
+	case protocol.GroupOCO:
+	case protocol.GroupEntry:
+		bracket = true
+	case protocol.GroupExit:
+		//子单按主单成交量激活，只支持限价和止损类订单
+		if bean.OrderType != protocol.TypeLimit && !isStopOrder(bean.OrderType) {
+			return nil, protocol.ReasonInvalidOrderType
+		}
+		bracket = true
+	default:
+		return nil, protocol.ReasonInvalidPayload
+	}
+	//组内订单按数量联动，不支持按金额下单
+	if len(bean.QuoteSize) > 0 {
+		if quoteSize, err := udecimal.Parse(bean.QuoteSize); err != nil || !quoteSize.IsZero() {
+			return nil, protocol.ReasonInvalidSize
+		}
+	}
+	g := b.groups.groups[bean.GroupId]
+	if g == nil {
+		return &orderGroup{id: bean.GroupId, userId: bean.UserId, bracket: bracket}, 0
+	}
+	if g.userId != bean.UserId || g.bracket != bracket || g.resolved {
+		return nil, protocol.ReasonGroupConflict
+	}
+	if bean.GroupRole == protocol.GroupEntry && len(g.entryId) > 0 {
+		return nil, protocol.ReasonGroupConflict
+	}
+	return g, 0
+}
+
+// 订单加入组，需在撮合前调用；返回 false 表示子单等待主单成交，暂不进入订单簿
+func (b *OrderBook) joinGroup(g *orderGroup, order *protocol.Order, role protocol.GroupRole) bool {
+	b.groups.groups[g.id] = g
+	b.groups.orders[order.Id] = g
+	switch role {
+	case protocol.GroupEntry:
+		g.entryId = order.Id
+	case protocol.GroupExit:
+		if g.entryFilled.IsZero() {
+			g.pending = append(g.pending, order)
+			return false
+		}
+		order.Size = g.entryFilled
+		order.HiddenSize = udecimal.Zero
+		g.legs = append(g.legs, order.Id)
+	default:
+		g.legs = append(g.legs, order.Id)
+	}
+	return true
+}
+
+// 订单是否还在订单簿、触发簿或等待激活
+func (b *OrderBook) isLive(id string) bool {
+	if order, _ := b.findOrder(id); order != nil {
+		return true
+	}
+	return b.triggers.get(id) != nil || b.groups.pendingOrder(id) != nil
+}
+
+// 组内订单成交，done 表示订单已全部成交
+func (b *OrderBook) onGroupFill(id string, size udecimal.Decimal, done bool, timestamp int64, logs *[]*OrderBookLog) {
+	g := b.groups.orders[id]
+	if g == nil {
+		return
+	}
+	if done {
+		delete(b.groups.orders, id)
+	}
+	if g.bracket && id == g.entryId {
+		g.entryFilled = g.entryFilled.Add(size)
+		g.entryDone = done
+		if !g.resolved {
+			b.sizeExits(g, timestamp, logs)
+		}
+	} else {
+		if done {
+			g.removeLeg(id)
+		}
+		if !g.resolved {
+			g.resolved = true
+			b.cancelGroup(g, id, timestamp, logs)
+		}
+	}
+	b.groups.cleanup(g)
+}
+
+// 组内订单被撤销或拒绝
+func (b *OrderBook) onGroupClose(id string, timestamp int64, logs *[]*OrderBookLog) {
+	g := b.groups.orders[id]
+	//改单、重复下单被拒绝时订单仍然有效
+	if g == nil || b.isLive(id) {
+		return
+	}
+	delete(b.groups.orders, id)
+	if g.bracket && id == g.entryId {
+		g.entryDone = true
+		//主单未成交就结束，子单一并撤销；已部分成交时子单保留
+		if g.entryFilled.IsZero() && !g.resolved {
+			g.resolved = true
+			b.cancelGroup(g, "", timestamp, logs)
+		}
+	} else {
+		g.removeLeg(id)
+		if !g.resolved {
+			g.resolved = true
+			b.cancelGroup(g, "", timestamp, logs)
+		}
+	}
+	b.groups.cleanup(g)
+}
+
+// 撤销组内除 except 外的腿和未激活的子单，主单不受影响
+func (b *OrderBook) cancelGroup(g *orderGroup, except string, timestamp int64, logs *[]*OrderBookLog) {
+	legs := g.legs
+	g.legs = nil
+	for _, id := range legs {
+		if id == except {
+			g.legs = append(g.legs, id)
+			continue
+		}
+		delete(b.groups.orders, id)
+		if order := b.triggers.get(id); order != nil {
+			b.cancelTrigger(order, protocol.ReasonGroupCancelled, timestamp, logs)
+		} else if order, q := b.findOrder(id); order != nil {
+			b.cancelResting(order, q, protocol.ReasonGroupCancelled, timestamp, logs)
+		}
+	}
+	for _, order := range g.pending {
+		delete(b.groups.orders, order.Id)
+		b.cancelPending(order, protocol.ReasonGroupCancelled, timestamp, logs)
+	}
+	g.pending = nil
+}
+
+// 主单成交后，已激活的子单调整到累计成交量，未激活的子单按累计成交量激活
+func (b *OrderBook) sizeExits(g *orderGroup, timestamp int64, logs *[]*OrderBookLog) {
+	for _, id := range g.legs {
+		b.resizeLeg(id, g.entryFilled, timestamp, logs)
+	}
+	pending := g.pending
+	g.pending = nil
+	for _, order := range pending {
+		order.Size = g.entryFilled
+		order.HiddenSize = udecimal.Zero
+		order.Timestamp = timestamp
+		g.legs = append(g.legs, order.Id)
+		b.activateExit(order, logs)
+	}
+}
+
+// 子单进入订单簿或触发簿
+func (b *OrderBook) activateExit(order *protocol.Order, logs *[]*OrderBookLog) {
+	if isStopOrder(order.OrderType) {
+		b.placeStopOrder(order, logs)
+		return
+	}
+	matchLogs := b.processLimitOrder(order)
+	*logs = append(*logs, *matchLogs...)
+	releaseLogSlice(matchLogs)
+	if exist, _ := b.findOrder(order.Id); exist != order {
+		releaseOrder(order)
+	} else {
+		b.trackExpiry(order)
+	}
+}
+
+// 调整子单数量，挂单加量后重新排队
+func (b *OrderBook) resizeLeg(id string, size udecimal.Decimal, timestamp int64, logs *[]*OrderBookLog) {
+	if order := b.triggers.get(id); order != nil {
+		if order.Size.Equal(size) {
+			return
+		}
+		log := NewAmendLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, size, order.Price, order.Size, order.OrderType, timestamp)
+		log.StopPrice = order.StopPrice.String()
+		*logs = append(*logs, log)
+		order.Size = size
+		return
+	}
+	order, q := b.findOrder(id)
+	if order == nil {
+		return
+	}
+	total := order.Size.Add(order.HiddenSize)
+	if total.Equal(size) {
+		return
+	}
+	q.RemoveOrder(order.Id, order.Price)
+	b.l3Delete(order)
+	log := NewAmendLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, size, order.Price, total, order.OrderType, timestamp)
+	*logs = append(*logs, log)
+	order.Size = size
+	order.HiddenSize = udecimal.Zero
+	order.Timestamp = timestamp
+	//价格不变，挂单时不会与对手盘交叉
+	b.restOrder(order, q, logs)
+}
+
+// 撤销未激活的子单
+func (b *OrderBook) cancelPendingExit(order *protocol.Order, timestamp int64) {
+	logs := acquireLogSlice()
+	b.removePendingExit(order, 0, timestamp, logs)
+	b.publishLogs(logs)
+}
+
+// 未激活的子单从组中移除并撤销，其余子单不受影响
+func (b *OrderBook) removePendingExit(order *protocol.Order, reason int32, timestamp int64, logs *[]*OrderBookLog) {
+	g := b.groups.orders[order.Id]
+	b.groups.removePending(g, order)
+	b.groups.cleanup(g)
+	b.cancelPending(order, reason, timestamp, logs)
+}
+
+// 未激活子单的撤单日志并回收，调用方负责从组中移除
+func (b *OrderBook) cancelPending(order *protocol.Order, reason int32, timestamp int64, logs *[]*OrderBookLog) {
+	log := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, udecimal.Zero, order.OrderType, timestamp)
+	log.StopPrice = order.StopPrice.String()
+	log.RejectReason = reason
+	*logs = append(*logs, log)
+	releaseOrder(order)
+}
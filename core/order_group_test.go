@@ -0,0 +1,153 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"testing"
+)
+
+func placeInGroup(t *testing.T, b *OrderBook, id, group string, role protocol.GroupRole, side protocol.Side, orderType protocol.OrderType, stop, price, size string) {
+	t.Helper()
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: id, Side: side, OrderType: orderType, StopPrice: stop, Price: price, Size: size, UserId: 9, GroupId: group, GroupRole: role,
+	})
+}
+
+// 最后一条日志
+func lastLog(memLog *MemoryLog) *OrderBookLog {
+	logs := memLog.GetLogs()
+	return logs[len(logs)-1]
+}
+
+// OCO 任一腿成交或撤销后撤销另一腿
+func TestOrderBook_OCO(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	tradeAt(t, b, "t0", "100", 1)
+	placeInGroup(t, b, "tp", "g1", protocol.GroupOCO, protocol.Sell, protocol.TypeLimit, "", "110", "2")
+	placeInGroup(t, b, "sl", "g1", protocol.GroupOCO, protocol.Sell, protocol.TypeStopMarket, "95", "0", "2")
+	assertInt64(t, 1, b.triggers.count(), "stop leg waits")
+
+	before := len(memLog.GetLogs())
+	placeLimit(t, b, "b1", 2, protocol.Buy, "110", "1")
+	logs := memLog.GetLogs()[before:]
+	if len(logs) != 2 || logs[0].Type != protocol.LogTypeMatch || logs[1].Type != protocol.LogTypeCancel {
+		t.Fatalf("expected match then cancel, got %d logs", len(logs))
+	}
+	assertString(t, "sl", logs[1].OrderId, "sibling cancelled")
+	assertInt64(t, protocol.ReasonGroupCancelled, int64(logs[1].RejectReason), "group cancel reason")
+	assertString(t, "95", logs[1].StopPrice, "cancelled stop price")
+	assertInt64(t, 0, b.triggers.count(), "stop leg removed")
+
+	//已结束的组不能再加腿，成交完毕后组被删除
+	placeInGroup(t, b, "late", "g1", protocol.GroupOCO, protocol.Sell, protocol.TypeLimit, "", "120", "1")
+	assertInt64(t, protocol.ReasonGroupConflict, int64(lastLog(memLog).RejectReason), "resolved group")
+	placeLimit(t, b, "b2", 2, protocol.Buy, "110", "1")
+	assertInt64(t, 0, int64(len(b.groups.groups)), "group removed")
+
+	//撤销一腿
+	placeInGroup(t, b, "tp2", "g2", protocol.GroupOCO, protocol.Sell, protocol.TypeLimit, "", "110", "1")
+	placeInGroup(t, b, "sl2", "g2", protocol.GroupOCO, protocol.Sell, protocol.TypeStopLimit, "95", "94", "1")
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "tp2", UserId: 9})
+	assertString(t, "sl2", lastLog(memLog).OrderId, "cancel cascades")
+	assertInt64(t, protocol.ReasonGroupCancelled, int64(lastLog(memLog).RejectReason), "cascade reason")
+	assertInt64(t, 0, b.triggers.count(), "no stops left")
+	assertInt64(t, 0, int64(len(b.groups.orders)), "no grouped orders")
+}
+
+// 括号单主单成交后按成交量激活子单，部分成交时调整子单数量
+func TestOrderBook_Bracket(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	tradeAt(t, b, "t0", "100", 1)
+	placeInGroup(t, b, "entry", "g1", protocol.GroupEntry, protocol.Buy, protocol.TypeLimit, "", "100", "3")
+	placeInGroup(t, b, "tp", "g1", protocol.GroupExit, protocol.Sell, protocol.TypeLimit, "", "110", "3")
+	placeInGroup(t, b, "sl", "g1", protocol.GroupExit, protocol.Sell, protocol.TypeStopMarket, "90", "0", "3")
+	assertInt64(t, int64(protocol.LogTypeOpen), int64(lastLog(memLog).Type), "exit accepted")
+	if order, _ := b.findOrder("tp"); order != nil {
+		t.Fatal("exit should not rest before entry fills")
+	}
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "tp", UserId: 9, NewPrice: "111"})
+	assertInt64(t, protocol.ReasonGroupPending, int64(lastLog(memLog).RejectReason), "amend pending exit")
+
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "1")
+	tp, _ := b.findOrder("tp")
+	if tp == nil {
+		t.Fatal("take profit should be active")
+	}
+	assertString(t, "1", tp.Size.String(), "take profit sized to fill")
+	assertString(t, "1", b.triggers.get("sl").Size.String(), "stop loss sized to fill")
+
+	placeLimit(t, b, "s2", 1, protocol.Sell, "100", "1")
+	tp, _ = b.findOrder("tp")
+	assertString(t, "2", tp.Size.String(), "take profit resized")
+	assertString(t, "2", b.triggers.get("sl").Size.String(), "stop loss resized")
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt64(t, 3, int64(len(restored.groups.orders)), "restored grouped orders")
+	assertString(t, "2", restored.groups.groups["g1"].entryFilled.String(), "restored entry fill")
+
+	//止盈成交后撤销止损，主单剩余部分成交不再调整子单
+	placeLimit(t, b, "b1", 2, protocol.Buy, "110", "2")
+	assertString(t, "sl", lastLog(memLog).OrderId, "stop loss cancelled")
+	assertInt64(t, protocol.ReasonGroupCancelled, int64(lastLog(memLog).RejectReason), "cancel reason")
+	placeLimit(t, b, "s3", 1, protocol.Sell, "100", "1")
+	assertInt64(t, 0, b.triggers.count(), "stop loss not reactivated")
+	assertInt64(t, 0, int64(len(b.groups.groups)), "group removed")
+}
+
+// 主单未成交就撤销时子单一并撤销
+func TestOrderBook_BracketEntryCancelled(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeInGroup(t, b, "tp", "g1", protocol.GroupExit, protocol.Sell, protocol.TypeLimit, "", "110", "1")
+	placeInGroup(t, b, "entry", "g1", protocol.GroupEntry, protocol.Buy, protocol.TypeLimit, "", "100", "1")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "other", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 8, GroupId: "g1", GroupRole: protocol.GroupExit,
+	})
+	assertInt64(t, protocol.ReasonGroupConflict, int64(lastLog(memLog).RejectReason), "other user")
+
+	before := len(memLog.GetLogs())
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "entry", UserId: 9})
+	logs := memLog.GetLogs()[before:]
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 cancel logs, got %d", len(logs))
+	}
+	assertString(t, "tp", logs[1].OrderId, "pending exit cancelled")
+	assertInt64(t, protocol.ReasonGroupCancelled, int64(logs[1].RejectReason), "cancel reason")
+	assertInt64(t, 0, int64(len(b.groups.groups)), "group removed")
+}
+
+// 未激活的子单按有效期到期撤销，主单之后成交不再激活
+func TestOrderBook_BracketPendingExitExpiry(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	placeInGroup(t, b, "entry", "g1", protocol.GroupEntry, protocol.Buy, protocol.TypeLimit, "", "100", "1")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "tp", Side: protocol.Sell, OrderType: protocol.TypeLimit, Price: "110", Size: "1", UserId: 9,
+		GroupId: "g1", GroupRole: protocol.GroupExit, TimeInForce: protocol.TifGTD, ExpireTime: 10, Timestamp: 1,
+	})
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInt64(t, 1, int64(restored.expiries.Len()), "restored pending expiry")
+
+	sendCmd(t, b, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 20})
+	assertString(t, "tp", lastLog(memLog).OrderId, "pending exit expired")
+	assertInt64(t, protocol.ReasonExpired, int64(lastLog(memLog).RejectReason), "expire reason")
+
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "1")
+	if order, _ := b.findOrder("tp"); order != nil {
+		t.Fatal("expired exit should not activate")
+	}
+	assertInt64(t, 0, int64(len(b.groups.groups)), "group removed")
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"time"
+
+	"github.com/quagmt/udecimal"
+)
+
+var two = udecimal.MustFromInt64(2, 0)
+
+// 当前价格带上下限，未开启或没有参考价时 ok=false
+func (b *OrderBook) priceBand() (lower, upper udecimal.Decimal, ok bool) {
+	if b.config.PriceBand.IsZero() {
+		return udecimal.Zero, udecimal.Zero, false
+	}
+	ref := b.ticker.lastPrice
+	if b.config.BandReference == protocol.BandRefMid {
+		bid, ask := b.bidQueue.PeakHeadOrder(), b.askQueue.PeakHeadOrder()
+		if bid != nil && ask != nil {
+			ref, _ = bid.Price.Add(ask.Price).Div(two)
+		}
+	}
+	if ref.IsZero() {
+		return udecimal.Zero, udecimal.Zero, false
+	}
+	delta, err := ref.Mul(b.config.PriceBand).Div(hundred)
+	if err != nil {
+		return udecimal.Zero, udecimal.Zero, false
+	}
+	return ref.Sub(delta), ref.Add(delta), true
+}
+
+// 价格是否超出价格带
+func outOfBand(price, lower, upper udecimal.Decimal) bool {
+	return price.LessThan(lower) || price.GreaterThan(upper)
+}
+
+// 限价是否超出价格带
+func (b *OrderBook) checkPriceBand(price udecimal.Decimal) int32 {
+	if lower, upper, ok := b.priceBand(); ok && outOfBand(price, lower, upper) {
+		return protocol.ReasonPriceBand
+	}
+	return 0
+}
+
+type breakerPoint struct {
+	timestamp int64
+	price     udecimal.Decimal
+}
+
+// 熔断统计，单调队列维护窗口内最高价和最低价
+type circuitBreaker struct {
+	maxQueue []breakerPoint
+	minQueue []breakerPoint
+	lastTime int64
+}
+
+// 加入成交价，返回窗口内最高价和最低价
+func (cb *circuitBreaker) add(timestamp int64, price udecimal.Decimal, window int64) (low, high udecimal.Decimal) {
+	//乱序成交按最后时间计入
+	if timestamp < cb.lastTime {
+		timestamp = cb.lastTime
+	}
+	cb.lastTime = timestamp
+	point := breakerPoint{timestamp: timestamp, price: price}
+	for len(cb.maxQueue) > 0 && cb.maxQueue[len(cb.maxQueue)-1].price.LessThanOrEqual(price) {
+		cb.maxQueue = cb.maxQueue[:len(cb.maxQueue)-1]
+	}
+	cb.maxQueue = append(cb.maxQueue, point)
+	for len(cb.minQueue) > 0 && cb.minQueue[len(cb.minQueue)-1].price.GreaterThanOrEqual(price) {
+		cb.minQueue = cb.minQueue[:len(cb.minQueue)-1]
+	}
+	cb.minQueue = append(cb.minQueue, point)
+	for cb.maxQueue[0].timestamp <= timestamp-window {
+		cb.maxQueue = cb.maxQueue[1:]
+	}
+	for cb.minQueue[0].timestamp <= timestamp-window {
+		cb.minQueue = cb.minQueue[1:]
+	}
+	return cb.minQueue[0].price, cb.maxQueue[0].price
+}
+
+func (cb *circuitBreaker) reset() {
+	cb.maxQueue = nil
+	cb.minQueue = nil
+}
+
+func (cb *circuitBreaker) snapshot() BreakerSnapshot {
+	return BreakerSnapshot{
+		LastTime: cb.lastTime,
+		MaxQueue: copyBreakerPoints(cb.maxQueue),
+		MinQueue: copyBreakerPoints(cb.minQueue),
+	}
+}
+
+func (cb *circuitBreaker) restore(snapshot *BreakerSnapshot) {
+	cb.lastTime = snapshot.LastTime
+	cb.maxQueue = copyBreakerPoints(snapshot.MaxQueue)
+	cb.minQueue = copyBreakerPoints(snapshot.MinQueue)
+}
+
+func copyBreakerPoints(points []breakerPoint) []breakerPoint {
+	if len(points) == 0 {
+		return nil
+	}
+	return append([]breakerPoint(nil), points...)
+}
+
+// 检查一笔成交是否触发熔断，触发后暂停交易对并追加状态日志
+func (b *OrderBook) checkCircuitBreaker(price udecimal.Decimal, timestamp int64, logs *[]*OrderBookLog) {
+	if b.config.BreakerPercent.IsZero() || b.state != protocol.OrderBookRunning {
+		return
+	}
+	low, high := b.breaker.add(timestamp, price, b.config.BreakerWindow)
+	move, err := high.Sub(low).Mul(hundred).Div(low)
+	if err != nil || move.LessThanOrEqual(b.config.BreakerPercent) {
+		return
+	}
+	b.breaker.reset()
+	b.state = protocol.OrderBookPause
+	*logs = append(*logs, NewStateLog(b.seqId.Add(1), b.marketId, b.state, protocol.ReasonCircuitBreaker, timestamp))
+}
+
+// 手动变更状态并推送状态日志
+func (b *OrderBook) changeState(state protocol.OrderBookState, reason int32) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if state == protocol.OrderBookRunning {
+		b.breaker.reset()
+	}
+	logs := acquireLogSlice()
+	*logs = append(*logs, NewStateLog(b.seqId.Add(1), b.marketId, state, reason, time.Now().Unix()))
+	b.publishLogs(logs)
+}
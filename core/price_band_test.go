@@ -0,0 +1,125 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"context"
+	"testing"
+)
+
+func newBandOrderBook(t *testing.T, rules *protocol.TradingRules) (*OrderBook, *MemoryLog) {
+	t.Helper()
+	config, err := DefaultMarketConfig().Apply(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memLog := NewMemoryLog()
+	return NewOrderBook("BTC-USDT", memLog, WithMarketConfig(config)), memLog
+}
+
+func tradeAt(t *testing.T, b *OrderBook, id, price string, ts int64) {
+	t.Helper()
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: id + "-s", Side: protocol.Sell, OrderType: protocol.TypeLimit, Price: price, Size: "1", UserId: 1, Timestamp: ts,
+	})
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: id + "-b", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: price, Size: "1", UserId: 2, Timestamp: ts,
+	})
+}
+
+// 限价超出价格带拒绝，市价扫单超出价格带后撤销剩余
+func TestOrderBook_PriceBand(t *testing.T) {
+	b, memLog := newBandOrderBook(t, &protocol.TradingRules{PriceBand: "10"})
+	tradeAt(t, b, "t1", "100", 1)
+
+	placeLimit(t, b, "hi", 3, protocol.Buy, "111", "1")
+	logs := memLog.GetLogs()
+	assertInt64(t, protocol.ReasonPriceBand, int64(logs[len(logs)-1].RejectReason), "limit out of band")
+
+	placeLimit(t, b, "s1", 4, protocol.Sell, "105", "1")
+	placeLimit(t, b, "s2", 4, protocol.Sell, "110", "1")
+	placeLimit(t, b, "s3", 4, protocol.Sell, "109", "1")
+	sendCmd(t, b, protocol.CmdUpdateConfig, &protocol.UpdateConfigCommand{TradingRules: protocol.TradingRules{PriceBand: "8"}})
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "m1", Side: protocol.Buy, OrderType: protocol.TypeMarket, Price: "0", Size: "3", UserId: 5,
+	})
+	logs = memLog.GetLogs()
+	tail := logs[len(logs)-2:]
+	assertInt64(t, int64(protocol.LogTypeMatch), int64(tail[0].Type), "fill inside band")
+	assertString(t, "s1", tail[0].MakerOrderId, "maker inside band")
+	assertInt64(t, int64(protocol.LogTypeCancel), int64(tail[1].Type), "cancel remainder")
+	assertInt64(t, protocol.ReasonPriceBand, int64(tail[1].RejectReason), "band reason")
+	assertString(t, "2", tail[1].Size, "cancelled remainder")
+	assertString(t, "s3", b.askQueue.PeakHeadOrder().Id, "asks outside band untouched")
+}
+
+// 窗口内波动超过阈值自动暂停
+func TestOrderBook_CircuitBreaker(t *testing.T) {
+	window := int64(60)
+	b, memLog := newBandOrderBook(t, &protocol.TradingRules{BreakerPercent: "5", BreakerWindow: &window})
+	tradeAt(t, b, "t1", "100", 10)
+	tradeAt(t, b, "t2", "102", 100) //t1 已移出窗口
+	tradeAt(t, b, "t3", "98", 120)
+	if b.state != protocol.OrderBookRunning {
+		t.Fatal("should still be running")
+	}
+	tradeAt(t, b, "t4", "103", 130)
+	if b.state != protocol.OrderBookPause {
+		t.Fatal("breaker should pause the market")
+	}
+	logs := memLog.GetLogs()
+	last := logs[len(logs)-1]
+	assertInt64(t, int64(protocol.LogTypeState), int64(last.Type), "state log")
+	assertInt64(t, protocol.ReasonCircuitBreaker, int64(last.RejectReason), "breaker reason")
+	assertInt64(t, int64(protocol.LogTypeMatch), int64(logs[len(logs)-2].Type), "trade before pause")
+
+	placeLimit(t, b, "late", 3, protocol.Buy, "100", "1")
+	logs = memLog.GetLogs()
+	assertInt64(t, protocol.ReasonStateHadDone, int64(logs[len(logs)-1].RejectReason), "paused rejects orders")
+
+	sendCmd(t, b, protocol.CmdResumeMarket, &protocol.ResumeMarketCommand{})
+	logs = memLog.GetLogs()
+	assertInt64(t, int64(protocol.OrderBookRunning), int64(logs[len(logs)-1].State), "resume state log")
+}
+
+// 熔断窗口和24小时统计随快照保存，恢复后与原订单簿行为一致
+func TestOrderBook_CircuitBreakerSnapshot(t *testing.T) {
+	window := int64(60)
+	b, _ := newBandOrderBook(t, &protocol.TradingRules{BreakerPercent: "5", BreakerWindow: &window})
+	tradeAt(t, b, "t1", "100", 100)
+	tradeAt(t, b, "t2", "98", 120)
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range []*OrderBook{b, restored} {
+		tradeAt(t, book, "t3", "103", 130)
+		assertInt64(t, int64(protocol.OrderBookPause), int64(book.state), "breaker pauses")
+		ticker, _ := book.GetTicker(context.Background(), &protocol.RequestGetTicker{})
+		assertInt64(t, 3, ticker.TradeCount, "trade count")
+		assertString(t, "100", ticker.Open.String(), "open")
+	}
+}
+
+// 止损连环触发时逐笔检查熔断，熔断后不再触发后续止损单
+func TestOrderBook_CircuitBreakerStopsCascade(t *testing.T) {
+	window := int64(60)
+	b, memLog := newBandOrderBook(t, &protocol.TradingRules{BreakerPercent: "5", BreakerWindow: &window})
+	tradeAt(t, b, "t0", "100", 0)
+	placeLimit(t, b, "s1", 3, protocol.Sell, "104", "1")
+	placeLimit(t, b, "s2", 3, protocol.Sell, "108", "1")
+	placeLimit(t, b, "s3", 3, protocol.Sell, "112", "1")
+	placeStop(t, b, "st1", protocol.Buy, protocol.TypeStopMarket, "103", "0", "1")
+	placeStop(t, b, "st2", protocol.Buy, protocol.TypeStopMarket, "107", "0", "1")
+
+	placeLimit(t, b, "b1", 4, protocol.Buy, "104", "1")
+	assertInt64(t, int64(protocol.OrderBookPause), int64(b.state), "breaker trips on triggered fill")
+	assertInt64(t, int64(protocol.LogTypeState), int64(lastLog(memLog).Type), "state log last")
+	assertInt64(t, 1, b.triggers.count(), "second stop not triggered")
+	assertString(t, "s3", b.askQueue.PeakHeadOrder().Id, "book not swept past the breaker")
+}
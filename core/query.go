@@ -0,0 +1,50 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"context"
+)
+
+// 默认深度档位数
+const DefaultDepthLimit = 20
+
+// 查询深度，经由 RingBuffer 在撮合线程读取，返回的是副本
+func (b *OrderBook) GetDepth(ctx context.Context, req *protocol.RequestGetDepth) (*protocol.DepthResponse, error) {
+	limit := int32(req.Limit)
+	if limit <= 0 {
+		limit = DefaultDepthLimit
+	}
+	resp := &protocol.DepthResponse{MarketId: b.marketId}
+	err := b.runInLoop(ctx, func() {
+		resp.Bids = b.bidQueue.GetDepth(limit)
+		resp.Asks = b.askQueue.GetDepth(limit)
+		resp.SeqId = b.seqId.Load()
+		resp.LastCmdSeqId = b.lastCmdSeqId.Load()
+		resp.LastLocalSeqId = b.lastLocalSeqId
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 查询订单簿状态
+func (b *OrderBook) GetState(ctx context.Context, _ *protocol.RequestGetState) (*protocol.StateResponse, error) {
+	resp := &protocol.StateResponse{MarketId: b.marketId}
+	err := b.runInLoop(ctx, func() {
+		resp.State = b.state
+		resp.BidOrders = b.bidQueue.OrderCount()
+		resp.AskOrders = b.askQueue.OrderCount()
+		resp.BidDepth = b.bidQueue.OrderDepth()
+		resp.AskDepth = b.askQueue.OrderDepth()
+		resp.StopOrders = b.triggers.count()
+		resp.SeqId = b.seqId.Load()
+		resp.TradeId = b.tradeId.Load()
+		resp.LastCmdSeqId = b.lastCmdSeqId.Load()
+		resp.LastLocalSeqId = b.lastLocalSeqId
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
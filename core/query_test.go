@@ -0,0 +1,78 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 查询与写指令并发时，结果来自撮合线程的一致视图
+func TestOrderBook_QueryWhileRunning(t *testing.T) {
+	b, _ := newTestOrderBook()
+	b.Start()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			b.PlaceOrder(&protocol.PlaceOrderCommand{
+				OrderId: fmt.Sprintf("b%d", i), Side: protocol.Buy, OrderType: protocol.TypeLimit,
+				Price: fmt.Sprintf("%d", 100+i%5), Size: "1", UserId: 1,
+			})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		depth, err := b.GetDepth(context.Background(), &protocol.RequestGetDepth{Limit: 3})
+		assertError(t, err, false, "get depth")
+		if len(depth.Bids) > 3 {
+			t.Fatalf("depth limit exceeded: %d", len(depth.Bids))
+		}
+	}
+	wg.Wait()
+
+	state, err := b.GetState(context.Background(), &protocol.RequestGetState{})
+	assertError(t, err, false, "get state")
+	assertInt64(t, 200, state.BidOrders, "bid orders")
+	assertInt64(t, 5, state.BidDepth, "bid depth")
+	assertInt64(t, 200, state.LastLocalSeqId, "last local seq")
+
+	depth, _ := b.GetDepth(context.Background(), &protocol.RequestGetDepth{Limit: 2})
+	assertInt64(t, 2, int64(len(depth.Bids)), "depth levels")
+	assertString(t, "104", depth.Bids[0].Price.String(), "best bid")
+	assertString(t, "40", depth.Bids[0].Size.String(), "best bid size")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assertError(t, b.Shutdown(ctx), false, "shutdown")
+}
+
+// 查询在撮合线程繁忙时随 ctx 返回，停机后返回专用错误
+func TestOrderBook_QueryContextAndShutdown(t *testing.T) {
+	b, _ := newTestOrderBook()
+	b.Start()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go b.runInLoop(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err := b.GetDepth(ctx, &protocol.RequestGetDepth{Limit: 1})
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	close(release)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assertError(t, b.Shutdown(ctx), false, "shutdown")
+	if _, err := b.GetState(ctx, &protocol.RequestGetState{}); err != ErrOrderBookShutdown {
+		t.Fatalf("expected shutdown error, got %v", err)
+	}
+}
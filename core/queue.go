@@ -7,9 +7,16 @@ import (
 	"time"
 )
 
+// 价格档位变化(L2 增量)，Size 为该档最新总量，为 0 表示档位已删除
 type UpdateEvent struct {
-	Price string `json:"price"`
-	Size  string `json:"size"`
+	MarketId string `json:"marketId"`
+	CmdSeqId int64  `json:"cmdSeqId"` //产生变化的指令ID
+	//产生变化的本地编号指令ID，CmdSeqId 为 0 时有效
+	LocalSeqId int64         `json:"localSeqId"`
+	Side       protocol.Side `json:"side"`
+	Price      string        `json:"price"`
+	Size       string        `json:"size"`
+	Count      int64         `json:"count"`
 }
 
 type priceUnit struct {
@@ -27,6 +34,8 @@ type queue struct {
 	orderPrices *SkipList
 	priceList   map[udecimal.Decimal]*priceUnit
 	orders      map[string]*protocol.Order
+	users       map[int64]map[string]*protocol.Order             //用户ID -> 挂单
+	listener    func(side protocol.Side, price udecimal.Decimal) //价格档位变化回调
 }
 
 const PriceCapacity = 102400
@@ -37,6 +46,7 @@ func NewBuyerQueue() *queue {
 		orderPrices: NewSkipList(PriceCapacity, time.Now().Unix(), true),
 		priceList:   make(map[udecimal.Decimal]*priceUnit),
 		orders:      make(map[string]*protocol.Order),
+		users:       make(map[int64]map[string]*protocol.Order),
 	}
 }
 
@@ -46,6 +56,7 @@ func NewSellerQueue() *queue {
 		orderPrices: NewSkipList(PriceCapacity, time.Now().Unix(), false),
 		priceList:   make(map[udecimal.Decimal]*priceUnit),
 		orders:      make(map[string]*protocol.Order),
+		users:       make(map[int64]map[string]*protocol.Order),
 	}
 }
 
@@ -53,11 +64,40 @@ func (q *queue) GetOrder(id string) *protocol.Order {
 	return q.orders[id]
 }
 
+// 用户的挂单，只能在撮合线程调用，遍历期间不能修改队列
+func (q *queue) UserOrders(userId int64) map[string]*protocol.Order {
+	return q.users[userId]
+}
+
+func (q *queue) addUserOrder(order *protocol.Order) {
+	orders, ok := q.users[order.UserId]
+	if !ok {
+		orders = make(map[string]*protocol.Order)
+		q.users[order.UserId] = orders
+	}
+	orders[order.Id] = order
+}
+
+func (q *queue) removeUserOrder(order *protocol.Order) {
+	orders := q.users[order.UserId]
+	delete(orders, order.Id)
+	if len(orders) == 0 {
+		delete(q.users, order.UserId)
+	}
+}
+
+func (q *queue) notify(price udecimal.Decimal) {
+	if q.listener != nil {
+		q.listener(q.size, price)
+	}
+}
+
 // 添加订单
 func (q *queue) PutOrder(order *protocol.Order, isFront bool) error {
 	if order == nil || len(order.Id) <= 0 || order.Price.LessThanOrEqual(udecimal.Zero) {
 		return errors.New("Put New Order failed!")
 	}
+	defer q.notify(order.Price)
 	unit, ok := q.priceList[order.Price]
 	if !ok {
 		//no price orders, init first one
@@ -71,6 +111,7 @@ func (q *queue) PutOrder(order *protocol.Order, isFront bool) error {
 		order.Next = nil
 
 		q.orders[order.Id] = order
+		q.addUserOrder(order)
 		q.priceList[order.Price] = unit
 		q.orderPrices.Insert(order.Price)
 		q.totalOrders++
@@ -98,6 +139,7 @@ func (q *queue) PutOrder(order *protocol.Order, isFront bool) error {
 		unit.totalSize = unit.totalSize.Add(order.Size)
 		unit.count++
 		q.orders[order.Id] = order
+		q.addUserOrder(order)
 		q.totalOrders++
 	}
 	return nil
@@ -113,6 +155,7 @@ func (q *queue) RemoveOrder(id string, price udecimal.Decimal) (bool, error) {
 	if !ok {
 		return false, errors.New("not found order by id")
 	}
+	defer q.notify(price)
 	if order.Prev != nil {
 		order.Prev.Next = order.Next
 	} else {
@@ -130,6 +173,7 @@ func (q *queue) RemoveOrder(id string, price udecimal.Decimal) (bool, error) {
 	unit.count--
 	//remove from order map
 	delete(q.orders, id)
+	q.removeUserOrder(order)
 	q.totalOrders--
 	//if current price level no order,remove
 	if unit.count <= 0 {
@@ -157,6 +201,7 @@ func (q *queue) UpdateOrderSize(id string, newSize udecimal.Decimal) error {
 	diff := order.Size.Sub(newSize)
 	unit.totalSize = unit.totalSize.Sub(diff)
 	order.Size = newSize
+	q.notify(order.Price)
 	return nil
 }
 
@@ -182,6 +227,16 @@ func (q *queue) PopHeadOrder() *protocol.Order {
 	q.RemoveOrder(order.Id, order.Price)
 	return order
 }
+
+// 价格档位总量和订单数
+func (q *queue) Level(price udecimal.Decimal) (udecimal.Decimal, int64) {
+	unit, ok := q.priceList[price]
+	if !ok {
+		return udecimal.Zero, 0
+	}
+	return unit.totalSize, unit.count
+}
+
 func (q *queue) OrderCount() int64 {
 	return q.totalOrders
 }
@@ -189,7 +244,48 @@ func (q *queue) OrderDepth() int64 {
 	return q.depths
 }
 
-// 获取快照
+// 对手盘在限价内的累计数量能否满足 size，userId 的挂单按自成交防护模式处理:
+// 撤销旧单时同用户挂单不计入，其他模式下新单在遇到同用户挂单前必须已足量
+func (q *queue) CanFill(price udecimal.Decimal, size udecimal.Decimal, userId int64, stpMode protocol.STPMode) bool {
+	total := udecimal.Zero
+	it := q.orderPrices.Iterator()
+	for it.Valid() {
+		levelPrice := it.Value()
+		if (q.size == protocol.Sell && levelPrice.GreaterThan(price)) ||
+			(q.size == protocol.Buy && levelPrice.LessThan(price)) {
+			break
+		}
+		if unit, ok := q.priceList[levelPrice]; ok {
+			//冰山单隐藏部分在本档可见部分之后才能成交，遇到同用户挂单时不可达
+			hidden, blocked := udecimal.Zero, false
+			for order := unit.head; order != nil; order = order.Next {
+				if stpMode != protocol.STPNone && order.UserId == userId {
+					if stpMode == protocol.STPCancelOldest {
+						continue
+					}
+					blocked = true
+					break
+				}
+				total = total.Add(order.Size)
+				hidden = hidden.Add(order.HiddenSize)
+				if total.GreaterThanOrEqual(size) {
+					return true
+				}
+			}
+			if blocked {
+				return false
+			}
+			total = total.Add(hidden)
+			if total.GreaterThanOrEqual(size) {
+				return true
+			}
+		}
+		it.Next()
+	}
+	return false
+}
+
+// 获取快照，只能在撮合线程调用，其他协程通过 OrderBook 查询接口读取
 func (q *queue) GetSnapshot() []*protocol.Order {
 	snapshots := make([]*protocol.Order, 0, q.totalOrders)
 	it := q.orderPrices.Iterator()
@@ -202,18 +298,10 @@ func (q *queue) GetSnapshot() []*protocol.Order {
 		}
 		order := unit.head
 		for order != nil {
-			od := &protocol.Order{
-				Id:           order.Id,
-				Side:         order.Side,
-				Price:        order.Price,
-				Size:         order.Size,
-				UserId:       order.UserId,
-				OrderType:    order.OrderType,
-				Timestamp:    order.Timestamp,
-				VisibleLimit: order.VisibleLimit,
-				HiddenSize:   order.HiddenSize,
-			}
-			snapshots = append(snapshots, od)
+			od := *order
+			od.Prev = nil
+			od.Next = nil
+			snapshots = append(snapshots, &od)
 			order = order.Next
 		}
 		it.Next()
@@ -221,6 +309,7 @@ func (q *queue) GetSnapshot() []*protocol.Order {
 	return snapshots
 }
 
+// 获取前 limit 档深度，只能在撮合线程调用
 func (q *queue) GetDepth(limit int32) []*protocol.OrderDepth {
 	result := make([]*protocol.OrderDepth, 0, limit)
 	count := int32(0)
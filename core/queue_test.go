@@ -183,6 +183,29 @@ func TestQueue_RemoveOrder(t *testing.T) {
 	assertError(t, err, true, "Remove non-existent order error")
 }
 
+// 测试按用户索引挂单
+func TestQueue_UserOrders(t *testing.T) {
+	q := NewSellerQueue()
+	q.PutOrder(&protocol.Order{Id: "1", UserId: 7, Price: udecimal.MustFromInt64(100, 0), Size: udecimal.MustFromInt64(1, 0)}, false)
+	q.PutOrder(&protocol.Order{Id: "2", UserId: 7, Price: udecimal.MustFromInt64(101, 0), Size: udecimal.MustFromInt64(1, 0)}, false)
+	q.PutOrder(&protocol.Order{Id: "3", UserId: 8, Price: udecimal.MustFromInt64(100, 0), Size: udecimal.MustFromInt64(1, 0)}, false)
+	assertInt64(t, 2, int64(len(q.UserOrders(7))), "user 7 orders")
+
+	q.RemoveOrder("1", udecimal.MustFromInt64(100, 0))
+	assertInt64(t, 1, int64(len(q.UserOrders(7))), "after remove")
+	//成交出队后重新入队(部分成交)仍在索引中
+	head := q.PopHeadOrder()
+	assertString(t, "3", head.Id, "pop head")
+	assertInt64(t, 0, int64(len(q.UserOrders(8))), "popped order removed")
+	q.PutOrder(head, true)
+	assertInt64(t, 1, int64(len(q.UserOrders(8))), "re-put order indexed")
+
+	q.RemoveOrder("2", udecimal.MustFromInt64(101, 0))
+	if _, ok := q.users[7]; ok {
+		t.Error("empty user entry should be deleted")
+	}
+}
+
 // 测试更新订单数量
 func TestQueue_UpdateOrderSize(t *testing.T) {
 	q := NewBuyerQueue()
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 订单簿状态快照，订单按队列优先级排列
+type BookSnapshot struct {
+	MarketId       string
+	State          protocol.OrderBookState
+	LowSize        udecimal.Decimal
+	STPMode        protocol.STPMode
+	Config         MarketConfig
+	SeqId          int64
+	TradeId        int64
+	LastCmdSeqId   int64
+	LastLocalSeqId int64            //最后一条本地编号指令ID
+	LastPrice      udecimal.Decimal //最新成交价，价格带参考价
+	Bids           []*protocol.Order
+	Asks           []*protocol.Order
+	Stops          []*protocol.Order //未触发的止损单，按触发优先级排列
+	Groups         []*GroupSnapshot  //按组ID排序
+	Heartbeats     []HeartbeatSnapshot
+	Ticker         TickerSnapshot  //24小时统计窗口
+	Breaker        BreakerSnapshot //熔断窗口
+	LastTimerTime  int64           //最后一次定时指令时间
+	L3Seq          int64           //最后一个 L3 事件序号
+	L3HandleSeq    int64           //最后分配的 L3 句柄
+	L3Handles      []L3HandleSnapshot
+}
+
+// 24小时统计快照，只含有成交的分钟桶
+type TickerSnapshot struct {
+	LastTime int64
+	Buckets  []TickerBucketSnapshot
+}
+
+type TickerBucketSnapshot struct {
+	Minute      int64
+	Open        udecimal.Decimal
+	High        udecimal.Decimal
+	Low         udecimal.Decimal
+	Volume      udecimal.Decimal
+	QuoteVolume udecimal.Decimal
+	Count       int64
+}
+
+// 熔断窗口快照，单调队列原样保存
+type BreakerSnapshot struct {
+	LastTime int64
+	MaxQueue []breakerPoint
+	MinQueue []breakerPoint
+}
+
+// 心跳会话的撤单截止时间
+type HeartbeatSnapshot struct {
+	UserId   int64
+	Session  string
+	Deadline int64
+}
+
+// 挂单的 L3 匿名句柄，按订单ID排序
+type L3HandleSnapshot struct {
+	OrderId string
+	Handle  int64
+}
+
+// 订单组快照
+type GroupSnapshot struct {
+	Id          string
+	UserId      int64
+	Bracket     bool
+	EntryId     string
+	EntryDone   bool
+	EntryFilled udecimal.Decimal
+	Resolved    bool
+	Legs        []string
+	Pending     []*protocol.Order //未激活的子单
+}
+
+// 复制当前状态，需在撮合线程的指令边界调用
+func (b *OrderBook) takeSnapshot() *BookSnapshot {
+	return &BookSnapshot{
+		MarketId:       b.marketId,
+		State:          b.state,
+		LowSize:        b.lowSize,
+		STPMode:        b.stpMode,
+		Config:         b.config,
+		SeqId:          b.seqId.Load(),
+		TradeId:        b.tradeId.Load(),
+		LastCmdSeqId:   b.lastCmdSeqId.Load(),
+		LastLocalSeqId: b.lastLocalSeqId,
+		LastPrice:      b.ticker.lastPrice,
+		Bids:           b.bidQueue.GetSnapshot(),
+		Asks:           b.askQueue.GetSnapshot(),
+		Stops:          copyOrders(b.triggers.snapshot()),
+		Groups:         b.groups.snapshot(),
+		Heartbeats:     b.heartbeatSnapshot(),
+		Ticker:         b.ticker.snapshot(),
+		Breaker:        b.breaker.snapshot(),
+		LastTimerTime:  b.lastTimerTime,
+		L3Seq:          b.l3Seq,
+		L3HandleSeq:    b.l3HandleSeq,
+		L3Handles:      b.l3HandleSnapshot(),
+	}
+}
+
+// 用快照覆盖订单簿状态，只能在消费协程启动前调用
+func (b *OrderBook) restoreSnapshot(snapshot *BookSnapshot) {
+	b.bidQueue = NewBuyerQueue()
+	b.askQueue = NewSellerQueue()
+	b.state = snapshot.State
+	if snapshot.LowSize.GreaterThan(udecimal.Zero) {
+		b.lowSize = snapshot.LowSize
+	}
+	b.stpMode = snapshot.STPMode
+	b.config = snapshot.Config
+	b.seqId.Store(snapshot.SeqId)
+	b.tradeId.Store(snapshot.TradeId)
+	b.lastCmdSeqId.Store(snapshot.LastCmdSeqId)
+	b.lastLocalSeqId = snapshot.LastLocalSeqId
+	b.lastTimerTime = snapshot.LastTimerTime
+	b.ticker.restore(&snapshot.Ticker, snapshot.LastPrice)
+	b.breaker.restore(&snapshot.Breaker)
+	restoreQueue(b.bidQueue, snapshot.Bids)
+	restoreQueue(b.askQueue, snapshot.Asks)
+	b.triggers = newTriggerBook()
+	for _, od := range snapshot.Stops {
+		order := getOrderFromPool()
+		*order = *od
+		b.triggers.add(order)
+	}
+	b.groups = newGroupRegistry()
+	for _, gs := range snapshot.Groups {
+		b.groups.restore(gs)
+	}
+	b.heartbeats = make(map[sessionKey]int64, len(snapshot.Heartbeats))
+	for _, hb := range snapshot.Heartbeats {
+		b.heartbeats[sessionKey{userId: hb.UserId, session: hb.Session}] = hb.Deadline
+	}
+	b.rebuildExpiries()
+	b.bindDepthListener()
+	b.restoreL3(snapshot)
+}
+
+func restoreQueue(q *queue, orders []*protocol.Order) {
+	for _, od := range orders {
+		order := getOrderFromPool()
+		*order = *od
+		order.Prev = nil
+		order.Next = nil
+		q.PutOrder(order, false)
+	}
+}
+
+// 重放一条日志指令，已处理过的指令直接跳过，重放期间不推送日志
+// 上游编号和本地编号在日志中各自递增，按各自的最后编号判断是否已处理
+// 创建交易对指令由引擎记录和重放，订单簿跳过
+func (b *OrderBook) replayCmd(cmd *protocol.Command) {
+	if cmd.MarketId != b.marketId || cmd.Type == protocol.CmdCreateMarket {
+		return
+	}
+	if cmd.SeqId == 0 {
+		if cmd.LocalSeqId <= b.lastLocalSeqId {
+			return
+		}
+	} else if cmd.SeqId <= b.lastCmdSeqId.Load() {
+		return
+	}
+	b.replaying = true
+	b.curCmdSeqId = cmd.SeqId
+	b.curLocalSeqId = cmd.LocalSeqId
+	b.processCmd(cmd)
+	b.replaying = false
+	if cmd.SeqId == 0 {
+		b.lastLocalSeqId = cmd.LocalSeqId
+	} else {
+		b.lastCmdSeqId.Store(cmd.SeqId)
+	}
+}
+
+// 从快照和指令日志重建订单簿，snapshot 为空时从头重放
+// publishedSeqId 为下游已收到的最大日志ID，重放产生的之后的日志按原日志ID补推，快照之前的日志无法补推
+// opts 中的 WithJournal 只用于恢复之后的新指令，重放不会重复写日志
+func RecoverOrderBook(marketId string, tradeLog PushLog, snapshot *BookSnapshot, journalDir string, publishedSeqId int64, opts ...OrderBookOption) (*OrderBook, error) {
+	book := NewOrderBook(marketId, tradeLog, opts...)
+	book.publishedSeqId = publishedSeqId
+	if snapshot != nil {
+		book.restoreSnapshot(snapshot)
+	}
+	if len(journalDir) == 0 {
+		return book, nil
+	}
+	err := ReadJournal(journalDir, func(cmd *protocol.Command) error {
+		book.replayCmd(cmd)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// 复制订单，去掉链表指针
+func copyOrders(orders []*protocol.Order) []*protocol.Order {
+	result := make([]*protocol.Order, 0, len(orders))
+	for _, od := range orders {
+		order := *od
+		order.Prev = nil
+		order.Next = nil
+		result = append(result, &order)
+	}
+	return result
+}
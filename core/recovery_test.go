@@ -0,0 +1,215 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// 生成确定性的随机指令流
+func randomCommands(b *OrderBook, n int, seed int64) []*protocol.Command {
+	rd := rand.New(rand.NewSource(seed))
+	cmds := make([]*protocol.Command, 0, n)
+	for i := 0; i < n; i++ {
+		var cmdType protocol.CommandType
+		var payload any
+		orderId := fmt.Sprintf("o%d", rd.Intn(i+1))
+		userId := int64(rd.Intn(3) + 1)
+		switch rd.Intn(10) {
+		case 0, 1:
+			cmdType = protocol.CmdCancelOrder
+			payload = &protocol.CancelOrderCommand{OrderId: orderId, UserId: userId}
+		case 2:
+			cmdType = protocol.CmdAmendOrder
+			payload = &protocol.AmendOrderCommand{OrderId: orderId, UserId: userId, NewSize: fmt.Sprintf("%d", rd.Intn(5)+1)}
+		default:
+			cmdType = protocol.CmdPlaceOrder
+			side := protocol.Buy
+			if rd.Intn(2) == 0 {
+				side = protocol.Sell
+			}
+			place := &protocol.PlaceOrderCommand{
+				OrderId:   fmt.Sprintf("o%d", i),
+				Side:      side,
+				OrderType: protocol.TypeLimit,
+				Price:     fmt.Sprintf("%d", 95+rd.Intn(10)),
+				Size:      fmt.Sprintf("%d", rd.Intn(10)+1),
+				UserId:    userId,
+				Timestamp: int64(i),
+			}
+			if rd.Intn(5) == 0 {
+				place.VisibleLimit = "2"
+			}
+			if rd.Intn(8) == 0 {
+				place.OrderType = protocol.TypeStopLimit
+				place.StopPrice = fmt.Sprintf("%d", 95+rd.Intn(10))
+			}
+			payload = place
+		}
+		bs, _ := b.serializer.Marshal(payload)
+		cmds = append(cmds, &protocol.Command{MarketId: b.marketId, Type: cmdType, Payload: bs})
+	}
+	return cmds
+}
+
+func assertSameOrders(t *testing.T, expected, actual []*protocol.Order, msg string) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("%s: expected %d orders, got %d", msg, len(expected), len(actual))
+	}
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		if e.Id != a.Id || !e.Price.Equal(a.Price) || !e.Size.Equal(a.Size) ||
+			!e.HiddenSize.Equal(a.HiddenSize) || e.UserId != a.UserId {
+			t.Fatalf("%s[%d]: expected %+v, got %+v", msg, i, e, a)
+		}
+	}
+}
+
+// 运行中途崩溃，从快照+日志恢复后状态与原订单簿一致
+func TestRecoverOrderBook(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := OpenFileJournal(FileJournalConfig{Dir: dir, SyncPolicy: SyncEveryCommand})
+	origin := NewOrderBook("BTC-USDT", NewMemoryLog(), WithJournal(journal))
+	cmds := randomCommands(origin, 500, 7)
+
+	var snapshot *BookSnapshot
+	for i, cmd := range cmds {
+		origin.OnEvent(&protocol.InputEvent{Cmd: cmd})
+		if i == 200 {
+			snapshot = origin.takeSnapshot()
+		}
+	}
+	//模拟崩溃：不做任何清理，直接丢弃订单簿
+	journal.Close()
+
+	for _, base := range []*BookSnapshot{snapshot, nil} {
+		memLog := NewMemoryLog()
+		recovered, err := RecoverOrderBook("BTC-USDT", memLog, base, dir, origin.seqId.Load())
+		if err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+		assertSameOrders(t, origin.bidQueue.GetSnapshot(), recovered.bidQueue.GetSnapshot(), "bids")
+		assertSameOrders(t, origin.askQueue.GetSnapshot(), recovered.askQueue.GetSnapshot(), "asks")
+		assertSameOrders(t, origin.triggers.snapshot(), recovered.triggers.snapshot(), "stops")
+		assertInt64(t, origin.seqId.Load(), recovered.seqId.Load(), "seqId")
+		assertInt64(t, origin.tradeId.Load(), recovered.tradeId.Load(), "tradeId")
+		assertInt64(t, origin.lastCmdSeqId.Load(), recovered.lastCmdSeqId.Load(), "lastCmdSeqId")
+		assertInt64(t, 0, int64(len(memLog.GetLogs())), "replay must not republish")
+	}
+}
+
+// 日志写入失败后订单簿停止，失败的记录不会在关闭时刷入日志，重放结果与原订单簿一致
+func TestRecoverOrderBook_JournalFailed(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := OpenFileJournal(FileJournalConfig{Dir: dir, SyncPolicy: SyncEveryCommand})
+	origin := NewOrderBook("BTC-USDT", NewMemoryLog(), WithJournal(journal))
+	cmds := randomCommands(origin, 200, 11)
+	for i, cmd := range cmds {
+		if i == 120 {
+			//模拟磁盘故障，之后的写入和刷盘都会失败
+			journal.file.Close()
+		}
+		origin.OnEvent(&protocol.InputEvent{Cmd: cmd})
+	}
+	assertInt64(t, int64(protocol.OrderBookStop), int64(origin.state), "book stopped")
+	if err := journal.Append(&protocol.Command{Type: protocol.CmdCancelOrder}); !errors.Is(err, ErrJournalFailed) {
+		t.Fatalf("expected journal failed, got %v", err)
+	}
+	journal.Close()
+
+	recovered, err := RecoverOrderBook("BTC-USDT", NewMemoryLog(), nil, dir, origin.seqId.Load())
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	assertSameOrders(t, origin.bidQueue.GetSnapshot(), recovered.bidQueue.GetSnapshot(), "bids")
+	assertSameOrders(t, origin.askQueue.GetSnapshot(), recovered.askQueue.GetSnapshot(), "asks")
+	assertSameOrders(t, origin.triggers.snapshot(), recovered.triggers.snapshot(), "stops")
+	assertInt64(t, origin.tradeId.Load(), recovered.tradeId.Load(), "tradeId")
+	assertInt64(t, origin.lastLocalSeqId, recovered.lastLocalSeqId, "lastLocalSeqId")
+	assertInt64(t, 120, recovered.lastLocalSeqId, "replayed until failure")
+}
+
+// 指令已落盘但日志推送前崩溃，恢复时按原日志ID补推下游未收到的日志
+func TestRecoverOrderBook_Republish(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := OpenFileJournal(FileJournalConfig{Dir: dir, SyncPolicy: SyncEveryCommand})
+	memLog := NewMemoryLog()
+	origin := NewOrderBook("BTC-USDT", memLog, WithJournal(journal))
+	cmds := randomCommands(origin, 300, 5)
+
+	var snapshot *BookSnapshot
+	var published int64
+	for i, cmd := range cmds {
+		origin.OnEvent(&protocol.InputEvent{Cmd: cmd})
+		if i == 100 {
+			snapshot = origin.takeSnapshot()
+		}
+		if i == 250 {
+			//本批之后的日志在推送前崩溃，下游只收到这之前的日志
+			published = origin.seqId.Load()
+		}
+	}
+	journal.Close()
+	var missing []*OrderBookLog
+	for _, log := range memLog.GetLogs() {
+		if log.SeqId > published {
+			missing = append(missing, log)
+		}
+	}
+	if len(missing) == 0 {
+		t.Fatal("expected logs after the watermark")
+	}
+
+	for _, base := range []*BookSnapshot{snapshot, nil} {
+		replayLog := NewMemoryLog()
+		if _, err := RecoverOrderBook("BTC-USDT", replayLog, base, dir, published); err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+		logs := replayLog.GetLogs()
+		assertInt64(t, int64(len(missing)), int64(len(logs)), "republished logs")
+		for i, log := range logs {
+			e := missing[i]
+			if e.SeqId != log.SeqId || e.Type != log.Type || e.OrderId != log.OrderId ||
+				e.TradeId != log.TradeId || e.Price != log.Price || e.Size != log.Size {
+				t.Fatalf("log %d: expected %+v, got %+v", i, e, log)
+			}
+		}
+	}
+}
+
+// 上游编号和本地编号的指令交错写入日志，从快照恢复时各自跳过已处理的部分
+func TestRecoverOrderBook_LocalSeqId(t *testing.T) {
+	dir := t.TempDir()
+	journal, _ := OpenFileJournal(FileJournalConfig{Dir: dir, SyncPolicy: SyncEveryCommand})
+	origin := NewOrderBook("BTC-USDT", NewMemoryLog(), WithJournal(journal))
+	send := func(seqId int64, cmdType protocol.CommandType, payload any) {
+		bs, _ := origin.serializer.Marshal(payload)
+		origin.OnEvent(&protocol.InputEvent{Cmd: &protocol.Command{MarketId: origin.marketId, SeqId: seqId, Type: cmdType, Payload: bs}})
+	}
+	place := func(id string, expire int64) *protocol.PlaceOrderCommand {
+		return &protocol.PlaceOrderCommand{
+			OrderId: id, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1,
+			TimeInForce: protocol.TifGTD, ExpireTime: expire, Timestamp: 1,
+		}
+	}
+	send(1, protocol.CmdPlaceOrder, place("o1", 10))
+	send(0, protocol.CmdPlaceOrder, place("local", 100))
+	snapshot := origin.takeSnapshot()
+	send(0, protocol.CmdTimer, &protocol.TimerCommand{Timestamp: 20})
+	send(2, protocol.CmdPlaceOrder, place("o2", 100))
+	journal.Close()
+
+	for _, base := range []*BookSnapshot{snapshot, nil} {
+		recovered, err := RecoverOrderBook("BTC-USDT", NewMemoryLog(), base, dir, origin.seqId.Load())
+		if err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+		assertSameOrders(t, origin.bidQueue.GetSnapshot(), recovered.bidQueue.GetSnapshot(), "bids")
+		assertInt64(t, origin.seqId.Load(), recovered.seqId.Load(), "seqId")
+		assertInt64(t, 2, recovered.lastCmdSeqId.Load(), "lastCmdSeqId")
+		assertInt64(t, 2, recovered.lastLocalSeqId, "lastLocalSeqId")
+	}
+}
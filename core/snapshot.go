@@ -0,0 +1,436 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 快照文件格式:
+// magic(4) | version(2) | body | crc32c(4)
+// body: marketId | state | lowSize | stpMode | config | seqId | tradeId | lastCmdSeqId | lastLocalSeqId | lastPrice | bids | asks | stops | groups | heartbeats | ticker | breaker | lastTimerTime | l3Seq | l3HandleSeq | l3Handles
+// 格式变化时递增版本，不支持的版本拒绝读取
+const (
+	SnapshotVersion uint16 = 1
+	snapshotMagic          = "MOMS"
+)
+
+var (
+	ErrSnapshotMagic    = errors.New("invalid snapshot magic")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+)
+
+// 在撮合线程的指令边界复制状态，编码和写入在调用方协程完成，不长时间阻塞撮合
+func (b *OrderBook) Snapshot(ctx context.Context, w io.Writer) error {
+	var snapshot *BookSnapshot
+	if err := b.runInLoop(ctx, func() {
+		snapshot = b.takeSnapshot()
+	}); err != nil {
+		return err
+	}
+	return WriteSnapshot(w, snapshot)
+}
+
+// 从快照恢复订单簿，之后可继续用 replay 追日志
+func RestoreOrderBook(r io.Reader, tradeLog PushLog, opts ...OrderBookOption) (*OrderBook, error) {
+	snapshot, err := ReadSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	book := NewOrderBook(snapshot.MarketId, tradeLog, opts...)
+	book.restoreSnapshot(snapshot)
+	return book, nil
+}
+
+func WriteSnapshot(w io.Writer, snapshot *BookSnapshot) error {
+	_, err := w.Write(encodeSnapshot(snapshot))
+	return err
+}
+
+func encodeSnapshot(snapshot *BookSnapshot) []byte {
+	buf := make([]byte, 0, 64+len(snapshot.Bids)*64+len(snapshot.Asks)*64)
+	buf = append(buf, snapshotMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, SnapshotVersion)
+	buf = appendBytes(buf, []byte(snapshot.MarketId))
+	buf = append(buf, byte(snapshot.State))
+	buf = appendDecimal(buf, snapshot.LowSize)
+	buf = append(buf, byte(snapshot.STPMode))
+	buf = appendMarketConfig(buf, &snapshot.Config)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.SeqId))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.TradeId))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.LastCmdSeqId))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.LastLocalSeqId))
+	buf = appendDecimal(buf, snapshot.LastPrice)
+	buf = appendSnapshotOrders(buf, snapshot.Bids)
+	buf = appendSnapshotOrders(buf, snapshot.Asks)
+	buf = appendSnapshotOrders(buf, snapshot.Stops)
+	buf = appendGroups(buf, snapshot.Groups)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(snapshot.Heartbeats)))
+	for _, hb := range snapshot.Heartbeats {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(hb.UserId))
+		buf = appendBytes(buf, []byte(hb.Session))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(hb.Deadline))
+	}
+	buf = appendTicker(buf, &snapshot.Ticker)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.Breaker.LastTime))
+	buf = appendBreakerPoints(buf, snapshot.Breaker.MaxQueue)
+	buf = appendBreakerPoints(buf, snapshot.Breaker.MinQueue)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.LastTimerTime))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.L3Seq))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(snapshot.L3HandleSeq))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(snapshot.L3Handles)))
+	for _, h := range snapshot.L3Handles {
+		buf = appendBytes(buf, []byte(h.OrderId))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(h.Handle))
+	}
+	return binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, journalCrcTable))
+}
+
+func ReadSnapshot(r io.Reader) (*BookSnapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(snapshotMagic)+2+4 {
+		return nil, errShortRecordBuf
+	}
+	if !bytes.Equal(data[:len(snapshotMagic)], []byte(snapshotMagic)) {
+		return nil, ErrSnapshotMagic
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, journalCrcTable) != sum {
+		return nil, ErrSnapshotChecksum
+	}
+	data = body[len(snapshotMagic):]
+	version := binary.BigEndian.Uint16(data)
+	if version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	dec := &snapshotDecoder{data: data[2:]}
+	snapshot := &BookSnapshot{}
+	snapshot.MarketId = string(dec.bytes())
+	snapshot.State = protocol.OrderBookState(dec.byte())
+	snapshot.LowSize = dec.decimal()
+	snapshot.STPMode = protocol.STPMode(dec.byte())
+	snapshot.Config = dec.marketConfig()
+	snapshot.SeqId = dec.int64()
+	snapshot.TradeId = dec.int64()
+	snapshot.LastCmdSeqId = dec.int64()
+	snapshot.LastLocalSeqId = dec.int64()
+	snapshot.LastPrice = dec.decimal()
+	snapshot.Bids = dec.orders()
+	snapshot.Asks = dec.orders()
+	snapshot.Stops = dec.orders()
+	snapshot.Groups = dec.groups()
+	heartbeats := dec.uint32()
+	for i := uint32(0); i < heartbeats && dec.err == nil; i++ {
+		hb := HeartbeatSnapshot{}
+		hb.UserId = dec.int64()
+		hb.Session = string(dec.bytes())
+		hb.Deadline = dec.int64()
+		snapshot.Heartbeats = append(snapshot.Heartbeats, hb)
+	}
+	snapshot.Ticker = dec.ticker()
+	snapshot.Breaker.LastTime = dec.int64()
+	snapshot.Breaker.MaxQueue = dec.breakerPoints()
+	snapshot.Breaker.MinQueue = dec.breakerPoints()
+	snapshot.LastTimerTime = dec.int64()
+	snapshot.L3Seq = dec.int64()
+	snapshot.L3HandleSeq = dec.int64()
+	handles := dec.uint32()
+	for i := uint32(0); i < handles && dec.err == nil; i++ {
+		h := L3HandleSnapshot{}
+		h.OrderId = string(dec.bytes())
+		h.Handle = dec.int64()
+		snapshot.L3Handles = append(snapshot.L3Handles, h)
+	}
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return snapshot, nil
+}
+
+func appendSnapshotOrders(buf []byte, orders []*protocol.Order) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(orders)))
+	for _, order := range orders {
+		buf = appendBytes(buf, []byte(order.Id))
+		buf = append(buf, byte(order.Side))
+		buf = appendBytes(buf, []byte(order.OrderType))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(order.UserId))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(order.Timestamp))
+		buf = appendDecimal(buf, order.Price)
+		buf = appendDecimal(buf, order.Size)
+		buf = appendDecimal(buf, order.VisibleLimit)
+		buf = appendDecimal(buf, order.HiddenSize)
+		buf = append(buf, byte(order.STPMode))
+		buf = appendDecimal(buf, order.StopPrice)
+		buf = appendDecimal(buf, order.TrailAmount)
+		buf = appendDecimal(buf, order.TrailPercent)
+		buf = appendDecimal(buf, order.TrailExtreme)
+		buf = appendDecimal(buf, order.LimitOffset)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(order.ExpireAt))
+		buf = appendBytes(buf, []byte(order.Session))
+	}
+	return buf
+}
+
+func appendGroups(buf []byte, groups []*GroupSnapshot) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(groups)))
+	for _, g := range groups {
+		buf = appendBytes(buf, []byte(g.Id))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(g.UserId))
+		buf = appendBool(buf, g.Bracket)
+		buf = appendBytes(buf, []byte(g.EntryId))
+		buf = appendBool(buf, g.EntryDone)
+		buf = appendDecimal(buf, g.EntryFilled)
+		buf = appendBool(buf, g.Resolved)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(g.Legs)))
+		for _, leg := range g.Legs {
+			buf = appendBytes(buf, []byte(leg))
+		}
+		buf = appendSnapshotOrders(buf, g.Pending)
+	}
+	return buf
+}
+
+func appendTicker(buf []byte, ticker *TickerSnapshot) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(ticker.LastTime))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(ticker.Buckets)))
+	for _, bucket := range ticker.Buckets {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(bucket.Minute))
+		buf = appendDecimal(buf, bucket.Open)
+		buf = appendDecimal(buf, bucket.High)
+		buf = appendDecimal(buf, bucket.Low)
+		buf = appendDecimal(buf, bucket.Volume)
+		buf = appendDecimal(buf, bucket.QuoteVolume)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(bucket.Count))
+	}
+	return buf
+}
+
+func appendBreakerPoints(buf []byte, points []breakerPoint) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(points)))
+	for _, point := range points {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(point.timestamp))
+		buf = appendDecimal(buf, point.price)
+	}
+	return buf
+}
+
+func appendBool(buf []byte, v bool) []byte {
+	if v {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+func appendMarketConfig(buf []byte, config *MarketConfig) []byte {
+	buf = appendDecimal(buf, config.TickSize)
+	buf = appendDecimal(buf, config.StepSize)
+	buf = appendDecimal(buf, config.MinSize)
+	buf = appendDecimal(buf, config.MaxSize)
+	buf = appendDecimal(buf, config.MinNotional)
+	buf = appendDecimal(buf, config.MaxNotional)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(config.PriceDecimals))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(config.SizeDecimals))
+	buf = appendDecimal(buf, config.PriceBand)
+	buf = append(buf, byte(config.BandReference))
+	buf = appendDecimal(buf, config.BreakerPercent)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(config.BreakerWindow))
+	return buf
+}
+
+func appendDecimal(buf []byte, d udecimal.Decimal) []byte {
+	return appendBytes(buf, []byte(d.String()))
+}
+
+// 顺序解码，遇到第一个错误后后续读取全部返回零值
+type snapshotDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *snapshotDecoder) bytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+	var bs []byte
+	bs, d.data, d.err = readBytes(d.data)
+	return bs
+}
+
+func (d *snapshotDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 1 {
+		d.err = errShortRecordBuf
+		return 0
+	}
+	v := d.data[0]
+	d.data = d.data[1:]
+	return v
+}
+
+func (d *snapshotDecoder) int64() int64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 8 {
+		d.err = errShortRecordBuf
+		return 0
+	}
+	v := int64(binary.BigEndian.Uint64(d.data))
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *snapshotDecoder) decimal() udecimal.Decimal {
+	bs := d.bytes()
+	if d.err != nil {
+		return udecimal.Zero
+	}
+	v, err := udecimal.Parse(string(bs))
+	if err != nil {
+		d.err = err
+	}
+	return v
+}
+
+func (d *snapshotDecoder) orders() []*protocol.Order {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.data) < 4 {
+		d.err = errShortRecordBuf
+		return nil
+	}
+	count := binary.BigEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	orders := make([]*protocol.Order, 0, min(int(count), len(d.data)))
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		order := &protocol.Order{}
+		order.Id = string(d.bytes())
+		order.Side = protocol.Side(d.byte())
+		order.OrderType = protocol.OrderType(d.bytes())
+		order.UserId = d.int64()
+		order.Timestamp = d.int64()
+		order.Price = d.decimal()
+		order.Size = d.decimal()
+		order.VisibleLimit = d.decimal()
+		order.HiddenSize = d.decimal()
+		order.STPMode = protocol.STPMode(d.byte())
+		order.StopPrice = d.decimal()
+		order.TrailAmount = d.decimal()
+		order.TrailPercent = d.decimal()
+		order.TrailExtreme = d.decimal()
+		order.LimitOffset = d.decimal()
+		order.ExpireAt = d.int64()
+		order.Session = string(d.bytes())
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+func (d *snapshotDecoder) int32() int32 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 4 {
+		d.err = errShortRecordBuf
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(d.data))
+	d.data = d.data[4:]
+	return v
+}
+
+func (d *snapshotDecoder) marketConfig() MarketConfig {
+	config := MarketConfig{}
+	config.TickSize = d.decimal()
+	config.StepSize = d.decimal()
+	config.MinSize = d.decimal()
+	config.MaxSize = d.decimal()
+	config.MinNotional = d.decimal()
+	config.MaxNotional = d.decimal()
+	config.PriceDecimals = d.int32()
+	config.SizeDecimals = d.int32()
+	config.PriceBand = d.decimal()
+	config.BandReference = protocol.BandReference(d.byte())
+	config.BreakerPercent = d.decimal()
+	config.BreakerWindow = d.int64()
+	return config
+}
+
+func (d *snapshotDecoder) uint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 4 {
+		d.err = errShortRecordBuf
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	return v
+}
+
+func (d *snapshotDecoder) groups() []*GroupSnapshot {
+	count := d.uint32()
+	groups := make([]*GroupSnapshot, 0, min(int(count), len(d.data)))
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		g := &GroupSnapshot{}
+		g.Id = string(d.bytes())
+		g.UserId = d.int64()
+		g.Bracket = d.byte() == 1
+		g.EntryId = string(d.bytes())
+		g.EntryDone = d.byte() == 1
+		g.EntryFilled = d.decimal()
+		g.Resolved = d.byte() == 1
+		legs := d.uint32()
+		for j := uint32(0); j < legs && d.err == nil; j++ {
+			g.Legs = append(g.Legs, string(d.bytes()))
+		}
+		g.Pending = d.orders()
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func (d *snapshotDecoder) ticker() TickerSnapshot {
+	ticker := TickerSnapshot{}
+	ticker.LastTime = d.int64()
+	count := d.uint32()
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		bucket := TickerBucketSnapshot{}
+		bucket.Minute = d.int64()
+		bucket.Open = d.decimal()
+		bucket.High = d.decimal()
+		bucket.Low = d.decimal()
+		bucket.Volume = d.decimal()
+		bucket.QuoteVolume = d.decimal()
+		bucket.Count = d.int64()
+		ticker.Buckets = append(ticker.Buckets, bucket)
+	}
+	return ticker
+}
+
+func (d *snapshotDecoder) breakerPoints() []breakerPoint {
+	count := d.uint32()
+	var points []breakerPoint
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		point := breakerPoint{}
+		point.timestamp = d.int64()
+		point.price = d.decimal()
+		points = append(points, point)
+	}
+	return points
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// 运行中的订单簿在撮合线程取快照，编码后可完整恢复
+func TestOrderBook_SnapshotRoundTrip(t *testing.T) {
+	b, _ := newTestOrderBook()
+	for _, cmd := range randomCommands(b, 300, 11) {
+		b.OnEvent(&protocol.InputEvent{Cmd: cmd})
+	}
+	b.Start()
+	var buf bytes.Buffer
+	if err := b.Snapshot(context.Background(), &buf); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b.Shutdown(ctx)
+
+	restored, err := RestoreOrderBook(bytes.NewReader(buf.Bytes()), NewMemoryLog())
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	assertString(t, b.marketId, restored.marketId, "marketId")
+	assertSameOrders(t, b.bidQueue.GetSnapshot(), restored.bidQueue.GetSnapshot(), "bids")
+	assertSameOrders(t, b.askQueue.GetSnapshot(), restored.askQueue.GetSnapshot(), "asks")
+	assertSameOrders(t, b.triggers.snapshot(), restored.triggers.snapshot(), "stops")
+	assertInt64(t, b.seqId.Load(), restored.seqId.Load(), "seqId")
+	assertInt64(t, b.tradeId.Load(), restored.tradeId.Load(), "tradeId")
+	assertInt64(t, b.lastCmdSeqId.Load(), restored.lastCmdSeqId.Load(), "lastCmdSeqId")
+	assertDecimal(t, b.lowSize, restored.lowSize, "lowSize")
+}
+
+// 数据损坏时校验失败
+func TestReadSnapshot_Corrupt(t *testing.T) {
+	b, _ := newTestOrderBook()
+	placeLimit(t, b, "o1", 1, protocol.Buy, "100", "1")
+	var buf bytes.Buffer
+	b.Snapshot(context.Background(), &buf)
+
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xff
+	if _, err := ReadSnapshot(bytes.NewReader(data)); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if _, err := ReadSnapshot(bytes.NewReader([]byte("XXXX0000000000"))); !errors.Is(err, ErrSnapshotMagic) {
+		t.Fatalf("expected magic error, got %v", err)
+	}
+}
+
+// 版本不符的快照拒绝读取
+func TestReadSnapshot_Version(t *testing.T) {
+	b, _ := newTestOrderBook()
+	placeLimit(t, b, "o1", 1, protocol.Buy, "100", "1")
+	data := encodeSnapshot(b.takeSnapshot())
+	binary.BigEndian.PutUint16(data[len(snapshotMagic):], SnapshotVersion+1)
+	binary.BigEndian.PutUint32(data[len(data)-4:], crc32.Checksum(data[:len(data)-4], journalCrcTable))
+	if _, err := ReadSnapshot(bytes.NewReader(data)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Fatalf("expected version error, got %v", err)
+	}
+}
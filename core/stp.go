@@ -0,0 +1,86 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 设置默认自成交防护模式，订单可单独指定
+func WithSTPMode(mode protocol.STPMode) OrderBookOption {
+	return func(b *OrderBook) {
+		b.stpMode = mode
+	}
+}
+
+// 是否需要自成交防护
+func isSelfTrade(order, maker *protocol.Order) bool {
+	return order.STPMode != protocol.STPNone && order.UserId == maker.UserId
+}
+
+// 处理 taker 与同一用户挂单相遇，maker 为对手盘队首订单
+// takerSize 为 taker 剩余数量，返回 taker 被扣减的数量，done 表示 taker 已撤销需结束撮合
+func (b *OrderBook) preventSelfTrade(order, maker *protocol.Order, takerSize udecimal.Decimal, targetQueue *queue, logs *[]*OrderBookLog) (reduced udecimal.Decimal, done bool) {
+	switch order.STPMode {
+	case protocol.STPCancelNewest:
+		b.stpCancelTaker(order, takerSize, udecimal.Zero, logs)
+		return takerSize, true
+	case protocol.STPCancelOldest:
+		b.stpCancelMaker(order, maker, targetQueue, logs)
+		return udecimal.Zero, false
+	case protocol.STPCancelBoth:
+		b.stpCancelMaker(order, maker, targetQueue, logs)
+		b.stpCancelTaker(order, takerSize, udecimal.Zero, logs)
+		return takerSize, true
+	case protocol.STPDecrementCancel:
+		makerSize := maker.Size.Add(maker.HiddenSize)
+		if takerSize.LessThan(makerSize) {
+			b.stpDecrementMaker(order, maker, takerSize, targetQueue, logs)
+			b.stpCancelTaker(order, takerSize, udecimal.Zero, logs)
+			return takerSize, true
+		}
+		b.stpCancelMaker(order, maker, targetQueue, logs)
+		if takerSize.Equal(makerSize) {
+			b.stpCancelTaker(order, takerSize, udecimal.Zero, logs)
+			return takerSize, true
+		}
+		b.stpCancelTaker(order, makerSize, takerSize.Sub(makerSize), logs)
+		return makerSize, false
+	}
+	return udecimal.Zero, false
+}
+
+// taker 撤销 size，left 为撤销后剩余
+func (b *OrderBook) stpCancelTaker(order *protocol.Order, size, left udecimal.Decimal, logs *[]*OrderBookLog) {
+	log := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, size, order.OrderType, order.Timestamp)
+	log.RejectReason = protocol.ReasonSelfTrade
+	log.LeftSize = left.String()
+	*logs = append(*logs, log)
+}
+
+// 整单撤销挂单，含冰山隐藏部分
+func (b *OrderBook) stpCancelMaker(order, maker *protocol.Order, targetQueue *queue, logs *[]*OrderBookLog) {
+	targetQueue.RemoveOrder(maker.Id, maker.Price)
+	b.l3Delete(maker)
+	log := NewCancelLog(b.seqId.Add(1), b.marketId, maker.Id, maker.UserId, maker.Side, maker.Price, maker.Size.Add(maker.HiddenSize), maker.OrderType, order.Timestamp)
+	log.RejectReason = protocol.ReasonSelfTrade
+	log.LeftSize = udecimal.Zero.String()
+	*logs = append(*logs, log)
+	releaseOrder(maker)
+}
+
+// 挂单扣减 size，保留队列位置，优先扣减隐藏部分
+func (b *OrderBook) stpDecrementMaker(order, maker *protocol.Order, size udecimal.Decimal, targetQueue *queue, logs *[]*OrderBookLog) {
+	if size.LessThanOrEqual(maker.HiddenSize) {
+		maker.HiddenSize = maker.HiddenSize.Sub(size)
+	} else {
+		visible := size.Sub(maker.HiddenSize)
+		maker.HiddenSize = udecimal.Zero
+		b.l3Reduce(maker, visible)
+		targetQueue.UpdateOrderSize(maker.Id, maker.Size.Sub(visible))
+	}
+	log := NewCancelLog(b.seqId.Add(1), b.marketId, maker.Id, maker.UserId, maker.Side, maker.Price, size, maker.OrderType, order.Timestamp)
+	log.RejectReason = protocol.ReasonSelfTrade
+	log.LeftSize = maker.Size.Add(maker.HiddenSize).String()
+	*logs = append(*logs, log)
+}
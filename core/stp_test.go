@@ -0,0 +1,125 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"testing"
+)
+
+// 各自成交防护模式：用户1挂 s1(2) s2(3)，再以 taker 买入 4
+func TestOrderBook_SelfTradePrevention(t *testing.T) {
+	cases := []struct {
+		name     string
+		mode     protocol.STPMode
+		types    []protocol.LogType
+		asks     []string //剩余卖单 id:size
+		resting  string   //taker 挂单剩余
+		cancelId string   //第一条防护撤单的订单
+	}{
+		{
+			name:     "cancel newest",
+			mode:     protocol.STPCancelNewest,
+			types:    []protocol.LogType{protocol.LogTypeCancel},
+			asks:     []string{"s1:2", "s2:3"},
+			cancelId: "b1",
+		},
+		{
+			name:     "cancel oldest",
+			mode:     protocol.STPCancelOldest,
+			types:    []protocol.LogType{protocol.LogTypeCancel, protocol.LogTypeCancel, protocol.LogTypeOpen},
+			resting:  "4",
+			cancelId: "s1",
+		},
+		{
+			name:     "cancel both",
+			mode:     protocol.STPCancelBoth,
+			types:    []protocol.LogType{protocol.LogTypeCancel, protocol.LogTypeCancel},
+			asks:     []string{"s2:3"},
+			cancelId: "s1",
+		},
+		{
+			name:     "decrement and cancel",
+			mode:     protocol.STPDecrementCancel,
+			types:    []protocol.LogType{protocol.LogTypeCancel, protocol.LogTypeCancel, protocol.LogTypeCancel, protocol.LogTypeCancel},
+			asks:     []string{"s2:1"},
+			cancelId: "s1",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b, memLog := newTestOrderBook()
+			placeLimit(t, b, "s1", 1, protocol.Sell, "100", "2")
+			placeLimit(t, b, "s2", 1, protocol.Sell, "101", "3")
+			sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+				OrderId: "b1", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "101", Size: "4", UserId: 1, STPMode: c.mode,
+			})
+			logs := memLog.GetLogs()[2:]
+			if len(logs) != len(c.types) {
+				t.Fatalf("log count: expected %d, got %d", len(c.types), len(logs))
+			}
+			for i, log := range logs {
+				if log.Type != c.types[i] {
+					t.Errorf("log[%d] type: expected %d, got %d", i, c.types[i], log.Type)
+				}
+				if log.Type == protocol.LogTypeCancel {
+					assertInt64(t, protocol.ReasonSelfTrade, int64(log.RejectReason), "stp reason")
+				}
+			}
+			assertString(t, c.cancelId, logs[0].OrderId, "first stp cancel")
+
+			asks := b.askQueue.GetSnapshot()
+			if len(asks) != len(c.asks) {
+				t.Fatalf("ask count: expected %d, got %d", len(c.asks), len(asks))
+			}
+			for i, order := range asks {
+				assertString(t, c.asks[i], order.Id+":"+order.Size.String(), "remaining ask")
+			}
+			if taker := b.bidQueue.GetOrder("b1"); taker != nil {
+				assertString(t, c.resting, taker.Size.String(), "taker resting")
+			} else if len(c.resting) > 0 {
+				t.Fatalf("taker should rest %s", c.resting)
+			}
+		})
+	}
+}
+
+// 交易对默认模式，其他用户的挂单照常成交
+func TestOrderBook_SelfTradeMarketDefault(t *testing.T) {
+	memLog := NewMemoryLog()
+	b := NewOrderBook("BTC-USDT", memLog, WithSTPMode(protocol.STPCancelOldest))
+	placeLimit(t, b, "s1", 1, protocol.Sell, "100", "1")
+	placeLimit(t, b, "s2", 2, protocol.Sell, "100", "1")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "b1", Side: protocol.Buy, OrderType: protocol.TypeMarket, Price: "0", Size: "1", UserId: 1,
+	})
+	logs := memLog.GetLogs()
+	assertLogTypes(t, logs, protocol.LogTypeOpen, protocol.LogTypeOpen, protocol.LogTypeCancel, protocol.LogTypeMatch)
+	assertString(t, "s1", logs[2].OrderId, "self order cancelled")
+	assertString(t, "s2", logs[3].MakerOrderId, "other user matched")
+	if b.askQueue.PeakHeadOrder() != nil {
+		t.Fatal("asks should be empty")
+	}
+}
+
+// FOK 不计入会被自成交防护撤掉的同用户挂单，不足量时整单拒绝
+func TestOrderBook_SelfTradeFOK(t *testing.T) {
+	for _, mode := range []protocol.STPMode{protocol.STPCancelOldest, protocol.STPCancelNewest, protocol.STPDecrementCancel} {
+		b, memLog := newTestOrderBook()
+		placeLimit(t, b, "self", 1, protocol.Sell, "100", "1")
+		placeLimit(t, b, "other", 2, protocol.Sell, "100", "1")
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: "fok", Side: protocol.Buy, OrderType: protocol.TypeFOK, Price: "100", Size: "2", UserId: 1, STPMode: mode,
+		})
+		assertInt64(t, protocol.ReasonFOKNotFilled, int64(lastLog(memLog).RejectReason), "fok rejected")
+		assertInt64(t, 2, b.askQueue.OrderCount(), "book untouched")
+	}
+
+	//同用户挂单排在后面时，撤销新单模式下前面的挂单足量即可成交
+	b, memLog := newTestOrderBook()
+	placeLimit(t, b, "other", 2, protocol.Sell, "100", "2")
+	placeLimit(t, b, "self", 1, protocol.Sell, "100", "1")
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "fok", Side: protocol.Buy, OrderType: protocol.TypeFOK, Price: "100", Size: "2", UserId: 1, STPMode: protocol.STPCancelNewest,
+	})
+	assertInt64(t, int64(protocol.LogTypeMatch), int64(lastLog(memLog).Type), "fok filled")
+	assertInt64(t, 1, b.askQueue.OrderCount(), "self order kept")
+}
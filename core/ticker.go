@@ -0,0 +1,182 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"context"
+
+	"github.com/quagmt/udecimal"
+)
+
+const (
+	tickerWindow      = 86400 //统计窗口24小时，单位秒
+	tickerBucketSize  = 60    //按分钟聚合
+	tickerBucketCount = tickerWindow / tickerBucketSize
+)
+
+var hundred = udecimal.MustFromInt64(100, 0)
+
+type tickerBucket struct {
+	minute      int64 //分钟序号 timestamp/60
+	open        udecimal.Decimal
+	high        udecimal.Decimal
+	low         udecimal.Decimal
+	volume      udecimal.Decimal
+	quoteVolume udecimal.Decimal
+	count       int64
+}
+
+// 24小时滚动统计，按成交时间戳分钟聚合，只在撮合线程读写
+type tickerStats struct {
+	buckets   [tickerBucketCount]tickerBucket
+	lastPrice udecimal.Decimal //按撮合顺序的最后成交价
+	lastTime  int64            //最大成交时间戳
+}
+
+func (ts *tickerStats) onTrade(timestamp int64, price, size, amount udecimal.Decimal) {
+	//最新价按撮合顺序取最后一笔成交，时间戳只用于分桶
+	ts.lastPrice = price
+	if timestamp > ts.lastTime {
+		ts.lastTime = timestamp
+	}
+	if timestamp < 0 {
+		return
+	}
+	minute := timestamp / tickerBucketSize
+	bucket := &ts.buckets[minute%tickerBucketCount]
+	//时间戳过旧，桶位已被更新的分钟占用，不计入统计，避免覆盖新数据
+	if bucket.count > 0 && minute < bucket.minute {
+		return
+	}
+	if bucket.count == 0 || bucket.minute != minute {
+		*bucket = tickerBucket{
+			minute: minute,
+			open:   price,
+			high:   price,
+			low:    price,
+		}
+	}
+	if price.GreaterThan(bucket.high) {
+		bucket.high = price
+	}
+	if price.LessThan(bucket.low) {
+		bucket.low = price
+	}
+	bucket.volume = bucket.volume.Add(size)
+	bucket.quoteVolume = bucket.quoteVolume.Add(amount)
+	bucket.count++
+}
+
+// 有成交的分钟桶，按桶位置排列
+func (ts *tickerStats) snapshot() TickerSnapshot {
+	snapshot := TickerSnapshot{LastTime: ts.lastTime}
+	for _, bucket := range ts.buckets {
+		if bucket.count == 0 {
+			continue
+		}
+		snapshot.Buckets = append(snapshot.Buckets, TickerBucketSnapshot{
+			Minute:      bucket.minute,
+			Open:        bucket.open,
+			High:        bucket.high,
+			Low:         bucket.low,
+			Volume:      bucket.volume,
+			QuoteVolume: bucket.quoteVolume,
+			Count:       bucket.count,
+		})
+	}
+	return snapshot
+}
+
+func (ts *tickerStats) restore(snapshot *TickerSnapshot, lastPrice udecimal.Decimal) {
+	*ts = tickerStats{lastPrice: lastPrice, lastTime: snapshot.LastTime}
+	for _, bs := range snapshot.Buckets {
+		ts.buckets[bs.Minute%tickerBucketCount] = tickerBucket{
+			minute:      bs.Minute,
+			open:        bs.Open,
+			high:        bs.High,
+			low:         bs.Low,
+			volume:      bs.Volume,
+			quoteVolume: bs.QuoteVolume,
+			count:       bs.Count,
+		}
+	}
+}
+
+// 统计截至 now 的24小时数据，now 为 0 时取最后成交时间
+func (ts *tickerStats) fill(resp *protocol.TickerResponse, now int64) {
+	if now < ts.lastTime {
+		now = ts.lastTime
+	}
+	nowMinute := now / tickerBucketSize
+	var first *tickerBucket
+	for i := range ts.buckets {
+		bucket := &ts.buckets[i]
+		if bucket.count == 0 || bucket.minute <= nowMinute-tickerBucketCount || bucket.minute > nowMinute {
+			continue
+		}
+		if first == nil || bucket.minute < first.minute {
+			first = bucket
+		}
+		if resp.TradeCount == 0 || bucket.high.GreaterThan(resp.High) {
+			resp.High = bucket.high
+		}
+		if resp.TradeCount == 0 || bucket.low.LessThan(resp.Low) {
+			resp.Low = bucket.low
+		}
+		resp.Volume = resp.Volume.Add(bucket.volume)
+		resp.QuoteVolume = resp.QuoteVolume.Add(bucket.quoteVolume)
+		resp.TradeCount += bucket.count
+	}
+	resp.LastPrice = ts.lastPrice
+	resp.Timestamp = now
+	if first == nil {
+		return
+	}
+	resp.Open = first.open
+	resp.PriceChange = ts.lastPrice.Sub(first.open)
+	if !first.open.IsZero() {
+		if percent, err := resp.PriceChange.Mul(hundred).Div(first.open); err == nil {
+			resp.PriceChangePercent = percent
+		}
+	}
+	if !resp.Volume.IsZero() {
+		if vwap, err := resp.QuoteVolume.Div(resp.Volume); err == nil {
+			resp.Vwap = vwap
+		}
+	}
+}
+
+// 成交日志更新行情统计
+func (b *OrderBook) updateTicker(logs []*OrderBookLog) {
+	for _, log := range logs {
+		if log.Type != protocol.LogTypeMatch {
+			continue
+		}
+		price, err := udecimal.Parse(log.Price)
+		if err != nil {
+			continue
+		}
+		size, _ := udecimal.Parse(log.Size)
+		amount, _ := udecimal.Parse(log.Amount)
+		b.ticker.onTrade(log.Timestamp, price, size, amount)
+	}
+}
+
+// 查询24小时行情，最优买卖价取自当前队列
+func (b *OrderBook) GetTicker(ctx context.Context, req *protocol.RequestGetTicker) (*protocol.TickerResponse, error) {
+	resp := &protocol.TickerResponse{MarketId: b.marketId}
+	err := b.runInLoop(ctx, func() {
+		b.ticker.fill(resp, req.Timestamp)
+		if head := b.bidQueue.PeakHeadOrder(); head != nil {
+			resp.BestBid = head.Price
+			resp.BestBidSize, _ = b.bidQueue.Level(head.Price)
+		}
+		if head := b.askQueue.PeakHeadOrder(); head != nil {
+			resp.BestAsk = head.Price
+			resp.BestAskSize, _ = b.askQueue.Level(head.Price)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
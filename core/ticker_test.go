@@ -0,0 +1,73 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"context"
+	"testing"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 24小时滚动统计，过期的成交移出窗口
+func TestOrderBook_Ticker(t *testing.T) {
+	b, _ := newTestOrderBook()
+	trade := func(id string, price, size string, ts int64) {
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id + "-s", Side: protocol.Sell, OrderType: protocol.TypeLimit, Price: price, Size: size, UserId: 1, Timestamp: ts,
+		})
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id + "-b", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: price, Size: size, UserId: 2, Timestamp: ts,
+		})
+	}
+	trade("t1", "100", "1", 1000)
+	trade("t2", "120", "1", 5000)
+	trade("t3", "110", "2", 9000)
+	placeLimit(t, b, "bid", 3, protocol.Buy, "105", "3")
+	placeLimit(t, b, "ask", 4, protocol.Sell, "115", "2")
+
+	ticker, err := b.GetTicker(context.Background(), &protocol.RequestGetTicker{MarketId: "BTC-USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertString(t, "110", ticker.LastPrice.String(), "last price")
+	assertString(t, "100", ticker.Open.String(), "open")
+	assertString(t, "120", ticker.High.String(), "high")
+	assertString(t, "100", ticker.Low.String(), "low")
+	assertString(t, "4", ticker.Volume.String(), "volume")
+	assertString(t, "440", ticker.QuoteVolume.String(), "quote volume")
+	assertString(t, "110", ticker.Vwap.String(), "vwap")
+	assertString(t, "10", ticker.PriceChange.String(), "price change")
+	assertString(t, "10", ticker.PriceChangePercent.String(), "price change percent")
+	assertInt64(t, 3, ticker.TradeCount, "trade count")
+	assertString(t, "105", ticker.BestBid.String(), "best bid")
+	assertString(t, "3", ticker.BestBidSize.String(), "best bid size")
+	assertString(t, "115", ticker.BestAsk.String(), "best ask")
+
+	//第一笔成交滑出窗口
+	ticker, err = b.GetTicker(context.Background(), &protocol.RequestGetTicker{MarketId: "BTC-USDT", Timestamp: 1000 + 86400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertString(t, "120", ticker.Open.String(), "rolled open")
+	assertString(t, "110", ticker.Low.String(), "rolled low")
+	assertString(t, "3", ticker.Volume.String(), "rolled volume")
+	assertInt64(t, 2, ticker.TradeCount, "rolled trade count")
+}
+
+// 成交时间戳回退时最新价仍取最后一笔成交
+func TestTickerStats_OutOfOrderTimestamp(t *testing.T) {
+	var ts tickerStats
+	one := udecimal.MustFromInt64(1, 0)
+	ts.onTrade(2000, udecimal.MustFromInt64(100, 0), one, one)
+	ts.onTrade(1000, udecimal.MustFromInt64(90, 0), one, one)
+	assertString(t, "90", ts.lastPrice.String(), "last price")
+	assertInt64(t, 2000, ts.lastTime, "last time")
+
+	//晚到一天以上的成交落在同一桶位，不覆盖更新的分钟
+	ts.onTrade(2000+tickerWindow, udecimal.MustFromInt64(100, 0), one, one)
+	ts.onTrade(2000, udecimal.MustFromInt64(50, 0), one, one)
+	bucket := ts.buckets[(2000/tickerBucketSize)%tickerBucketCount]
+	assertInt64(t, (2000+tickerWindow)/tickerBucketSize, bucket.minute, "newer minute kept")
+	assertInt64(t, 1, bucket.count, "newer bucket count")
+	assertString(t, "100", bucket.low.String(), "newer bucket low")
+}
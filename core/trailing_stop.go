@@ -0,0 +1,112 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"time"
+
+	"github.com/quagmt/udecimal"
+)
+
+func isTrailingStop(orderType protocol.OrderType) bool {
+	return orderType == protocol.TypeTrailingStop || orderType == protocol.TypeTrailingStopLimit
+}
+
+type trailParams struct {
+	amount  udecimal.Decimal
+	percent udecimal.Decimal
+	offset  udecimal.Decimal
+	stop    udecimal.Decimal //初始触发价
+}
+
+// 解析跟踪止损参数，以最新成交价为起点计算初始触发价
+func (b *OrderBook) parseTrailing(bean *protocol.PlaceOrderCommand) (trailParams, int32) {
+	var params trailParams
+	var err error
+	if len(bean.TrailAmount) > 0 {
+		if params.amount, err = udecimal.Parse(bean.TrailAmount); err != nil || params.amount.IsNeg() {
+			return params, protocol.ReasonInvalidStopPrice
+		}
+	}
+	if len(bean.TrailPercent) > 0 {
+		if params.percent, err = udecimal.Parse(bean.TrailPercent); err != nil || params.percent.IsNeg() || params.percent.GreaterThanOrEqual(hundred) {
+			return params, protocol.ReasonInvalidStopPrice
+		}
+	}
+	//二选一
+	if params.amount.IsZero() == params.percent.IsZero() {
+		return params, protocol.ReasonInvalidStopPrice
+	}
+	if len(bean.LimitOffset) > 0 {
+		if params.offset, err = udecimal.Parse(bean.LimitOffset); err != nil || params.offset.IsNeg() || b.config.checkPrice(params.offset) != 0 {
+			return params, protocol.ReasonInvalidPrice
+		}
+	}
+	last := b.ticker.lastPrice
+	if last.IsZero() {
+		return params, protocol.ReasonInvalidStopPrice
+	}
+	params.stop = b.trailStopPrice(bean.Side, params.amount, params.percent, last)
+	if params.stop.LessThanOrEqual(udecimal.Zero) {
+		return params, protocol.ReasonInvalidStopPrice
+	}
+	return params, 0
+}
+
+// 按极值计算触发价，卖单向下取整到价格精度，买单向上取整
+func (b *OrderBook) trailStopPrice(side protocol.Side, amount, percent, extreme udecimal.Decimal) udecimal.Decimal {
+	var distance udecimal.Decimal
+	if !amount.IsZero() {
+		distance = amount
+	} else {
+		distance, _ = extreme.Mul(percent).Div(hundred)
+	}
+	if side == protocol.Sell {
+		return b.config.roundPrice(extreme.Sub(distance), false)
+	}
+	return b.config.roundPrice(extreme.Add(distance), true)
+}
+
+// 跟踪止损限价单触发后的限价
+func trailLimitPrice(order *protocol.Order) udecimal.Decimal {
+	if order.Side == protocol.Sell {
+		return order.StopPrice.Sub(order.LimitOffset)
+	}
+	return order.StopPrice.Add(order.LimitOffset)
+}
+
+// 成交价创新高(卖)或新低(买)时移动触发价，每次调整都记录日志
+// 只遍历极值被越过的订单
+func (b *OrderBook) adjustTrailingStops(price udecimal.Decimal, timestamp int64, logs *[]*OrderBookLog) {
+	for _, order := range b.triggers.trailingCrossed(price) {
+		b.triggers.setExtreme(order, price)
+		stop := b.trailStopPrice(order.Side, order.TrailAmount, order.TrailPercent, price)
+		if order.Side == protocol.Sell && stop.LessThanOrEqual(order.StopPrice) ||
+			order.Side == protocol.Buy && stop.GreaterThanOrEqual(order.StopPrice) {
+			continue
+		}
+		oldStop := order.StopPrice
+		b.triggers.remove(order)
+		order.StopPrice = stop
+		b.triggers.add(order)
+		*logs = append(*logs, NewStopUpdateLog(b.seqId.Add(1), b.marketId, order, oldStop, timestamp))
+	}
+}
+
+// 跟踪止损触发价调整，PrePrice 为调整前的触发价
+func NewStopUpdateLog(seqID int64, marketID string, order *protocol.Order, oldStop udecimal.Decimal, timestamp int64) *OrderBookLog {
+	log := getOrderBookLog()
+	log.SeqId = seqID
+	log.Type = protocol.LogTypeStopUpdate
+	log.MarketId = marketID
+	log.Side = order.Side
+	log.Price = order.Price.String()
+	log.Size = order.Size.String()
+	log.OrderId = order.Id
+	log.UserId = order.UserId
+	log.OrderType = order.OrderType
+	log.StopPrice = order.StopPrice.String()
+	log.PrePrice = oldStop.String()
+	log.Timestamp = timestamp
+	log.CreateTime = time.Now().UTC()
+	return log
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"testing"
+
+	"github.com/quagmt/udecimal"
+)
+
+// 卖出跟踪止损随最高价上移，回落触及后按市价卖出
+func TestOrderBook_TrailingStopAmount(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	tradeAt(t, b, "t0", "100", 1)
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "ts", Side: protocol.Sell, OrderType: protocol.TypeTrailingStop, Price: "0", Size: "1", TrailAmount: "5", UserId: 9,
+	})
+	assertString(t, "95", b.triggers.get("ts").StopPrice.String(), "initial stop")
+
+	tradeAt(t, b, "t1", "110", 2)
+	logs := memLog.GetLogs()
+	last := logs[len(logs)-1]
+	assertInt64(t, int64(protocol.LogTypeStopUpdate), int64(last.Type), "adjust logged")
+	assertString(t, "105", last.StopPrice, "new stop")
+	assertString(t, "95", last.PrePrice, "old stop")
+
+	//回落但未触及，触发价不下移
+	tradeAt(t, b, "t2", "107", 3)
+	assertString(t, "105", b.triggers.get("ts").StopPrice.String(), "stop never moves down")
+
+	placeLimit(t, b, "bid", 2, protocol.Buy, "104", "1")
+	tradeAt(t, b, "t3", "105", 4)
+	logs = memLog.GetLogs()
+	tail := logs[len(logs)-2:]
+	assertInt64(t, int64(protocol.LogTypeTrigger), int64(tail[0].Type), "trailing stop triggered")
+	assertString(t, "ts", tail[1].OrderId, "fires as market")
+	assertString(t, "bid", tail[1].MakerOrderId, "fills resting bid")
+	assertInt64(t, 0, b.triggers.count(), "trigger book empty")
+}
+
+// 买入跟踪止损按百分比，触发后按触发价加偏移挂限价单
+func TestOrderBook_TrailingStopPercentLimit(t *testing.T) {
+	b, memLog := newRuledOrderBook(t)
+	tradeAt(t, b, "t0", "100", 1)
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "ts", Side: protocol.Buy, OrderType: protocol.TypeTrailingStopLimit, Price: "0", Size: "1",
+		TrailPercent: "3", LimitOffset: "1", UserId: 9,
+	})
+	assertString(t, "103", b.triggers.get("ts").StopPrice.String(), "initial stop")
+
+	tradeAt(t, b, "t1", "90", 2)
+	assertString(t, "93", b.triggers.get("ts").StopPrice.String(), "stop follows low")
+	tradeAt(t, b, "t2", "90.5", 3)
+	assertString(t, "93", b.triggers.get("ts").StopPrice.String(), "rounded to tick")
+	tradeAt(t, b, "t3", "89.5", 4)
+	assertString(t, "92.5", b.triggers.get("ts").StopPrice.String(), "percent rounded up to tick")
+
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "ts", UserId: 9, NewStopPrice: "95"})
+	logs := memLog.GetLogs()
+	assertInt64(t, protocol.ReasonInvalidStopPrice, int64(logs[len(logs)-1].RejectReason), "trailing stop price fixed")
+
+	tradeAt(t, b, "t4", "93", 5)
+	order := b.bidQueue.GetOrder("ts")
+	if order == nil {
+		t.Fatal("triggered trailing limit should rest")
+	}
+	assertString(t, "93.5", order.Price.String(), "limit = stop + offset")
+}
+
+// 限价偏移须符合价格精度；成交只调整极值被越过的跟踪止损单，普通止损单不在跟踪索引中
+func TestOrderBook_TrailingStopIndex(t *testing.T) {
+	b, memLog := newRuledOrderBook(t)
+	tradeAt(t, b, "t0", "100", 1)
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "bad", Side: protocol.Sell, OrderType: protocol.TypeTrailingStopLimit, Price: "0", Size: "1",
+		TrailAmount: "5", LimitOffset: "0.3", UserId: 9,
+	})
+	assertInt64(t, protocol.ReasonInvalidPrice, int64(lastLog(memLog).RejectReason), "off tick offset")
+
+	for _, id := range []string{"ts1", "ts2"} {
+		sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id, Side: protocol.Sell, OrderType: protocol.TypeTrailingStop, Price: "0", Size: "1", TrailAmount: "5", UserId: 9,
+		})
+	}
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "stop", Side: protocol.Sell, OrderType: protocol.TypeStopMarket, StopPrice: "90", Price: "0", Size: "1", UserId: 9,
+	})
+	assertInt64(t, 0, int64(len(b.triggers.trailingCrossed(udecimal.MustParse("100")))), "extreme not crossed")
+	assertInt64(t, 2, int64(len(b.triggers.trailingCrossed(udecimal.MustParse("101")))), "only trailing stops")
+
+	tradeAt(t, b, "t1", "110", 2)
+	assertString(t, "ts1", b.triggers.sells.head().Id, "queue order kept")
+	assertString(t, "110", b.triggers.get("ts2").TrailExtreme.String(), "extreme moved")
+	assertInt64(t, 0, int64(len(b.triggers.trailingCrossed(udecimal.MustParse("110")))), "index follows extreme")
+
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "ts1", UserId: 9})
+	assertInt64(t, 1, int64(len(b.triggers.trailingCrossed(udecimal.MustParse("111")))), "cancelled stop removed")
+}
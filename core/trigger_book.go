@@ -0,0 +1,336 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"time"
+
+	"github.com/quagmt/udecimal"
+)
+
+const triggerCapacity = 1024
+
+// 同一价格的止损单，按到达顺序排列；key 为排序价格，触发价或跟踪极值
+type triggerSide struct {
+	prices *SkipList
+	levels map[udecimal.Decimal][]*protocol.Order
+	key    func(*protocol.Order) udecimal.Decimal
+}
+
+func newTriggerSide(descending bool, key func(*protocol.Order) udecimal.Decimal) *triggerSide {
+	return &triggerSide{
+		prices: NewSkipList(triggerCapacity, time.Now().Unix(), descending),
+		levels: make(map[udecimal.Decimal][]*protocol.Order),
+		key:    key,
+	}
+}
+
+func stopPriceKey(order *protocol.Order) udecimal.Decimal {
+	return order.StopPrice
+}
+
+func trailExtremeKey(order *protocol.Order) udecimal.Decimal {
+	return order.TrailExtreme
+}
+
+// 最先触发的止损单
+func (ts *triggerSide) head() *protocol.Order {
+	ok, price := ts.prices.Min()
+	if !ok {
+		return nil
+	}
+	return ts.levels[price][0]
+}
+
+func (ts *triggerSide) add(order *protocol.Order) {
+	key := ts.key(order)
+	orders, ok := ts.levels[key]
+	if !ok {
+		ts.prices.Insert(key)
+	}
+	ts.levels[key] = append(orders, order)
+}
+
+func (ts *triggerSide) remove(order *protocol.Order) {
+	key := ts.key(order)
+	orders := ts.levels[key]
+	for i, o := range orders {
+		if o == order {
+			orders = append(orders[:i], orders[i+1:]...)
+			break
+		}
+	}
+	if len(orders) == 0 {
+		delete(ts.levels, key)
+		ts.prices.Remove(key)
+		return
+	}
+	ts.levels[key] = orders
+}
+
+// 按触发优先级排列的订单
+func (ts *triggerSide) snapshot() []*protocol.Order {
+	result := make([]*protocol.Order, 0)
+	for it := ts.prices.Iterator(); it.Valid(); it.Next() {
+		result = append(result, ts.levels[it.Value()]...)
+	}
+	return result
+}
+
+// 止损单触发簿，买单按触发价升序，卖单按触发价降序
+// 跟踪止损单另按极值索引，买单按极值降序，卖单按极值升序
+type triggerBook struct {
+	buys       *triggerSide //最新价 >= 触发价时触发
+	sells      *triggerSide //最新价 <= 触发价时触发
+	trailBuys  *triggerSide //最新价 < 极值时调整
+	trailSells *triggerSide //最新价 > 极值时调整
+	orders     map[string]*protocol.Order
+}
+
+func newTriggerBook() *triggerBook {
+	return &triggerBook{
+		buys:       newTriggerSide(false, stopPriceKey),
+		sells:      newTriggerSide(true, stopPriceKey),
+		trailBuys:  newTriggerSide(true, trailExtremeKey),
+		trailSells: newTriggerSide(false, trailExtremeKey),
+		orders:     make(map[string]*protocol.Order),
+	}
+}
+
+func (tb *triggerBook) side(side protocol.Side) *triggerSide {
+	if side == protocol.Buy {
+		return tb.buys
+	}
+	return tb.sells
+}
+
+func (tb *triggerBook) get(id string) *protocol.Order {
+	return tb.orders[id]
+}
+
+func (tb *triggerBook) count() int64 {
+	return int64(len(tb.orders))
+}
+
+func (tb *triggerBook) add(order *protocol.Order) {
+	tb.side(order.Side).add(order)
+	tb.orders[order.Id] = order
+	if isTrailingStop(order.OrderType) {
+		tb.trailSide(order.Side).add(order)
+	}
+}
+
+func (tb *triggerBook) remove(order *protocol.Order) {
+	tb.side(order.Side).remove(order)
+	delete(tb.orders, order.Id)
+	if isTrailingStop(order.OrderType) {
+		tb.trailSide(order.Side).remove(order)
+	}
+}
+
+func (tb *triggerBook) trailSide(side protocol.Side) *triggerSide {
+	if side == protocol.Buy {
+		return tb.trailBuys
+	}
+	return tb.trailSells
+}
+
+// 更新跟踪止损单的极值，触发价不变时保留在触发簿中的排队位置
+func (tb *triggerBook) setExtreme(order *protocol.Order, extreme udecimal.Decimal) {
+	ts := tb.trailSide(order.Side)
+	ts.remove(order)
+	order.TrailExtreme = extreme
+	ts.add(order)
+}
+
+// 极值被 price 越过的跟踪止损单，买单在前，各自按极值由近及远排列
+func (tb *triggerBook) trailingCrossed(price udecimal.Decimal) []*protocol.Order {
+	var result []*protocol.Order
+	for it := tb.trailBuys.prices.Iterator(); it.Valid() && price.LessThan(it.Value()); it.Next() {
+		result = append(result, tb.trailBuys.levels[it.Value()]...)
+	}
+	for it := tb.trailSells.prices.Iterator(); it.Valid() && price.GreaterThan(it.Value()); it.Next() {
+		result = append(result, tb.trailSells.levels[it.Value()]...)
+	}
+	return result
+}
+
+// 取出被 price 触发的第一个止损单，买单优先
+func (tb *triggerBook) popTriggered(price udecimal.Decimal) *protocol.Order {
+	for _, ts := range []*triggerSide{tb.buys, tb.sells} {
+		if order := ts.head(); order != nil && isTriggered(order, price) {
+			tb.remove(order)
+			return order
+		}
+	}
+	return nil
+}
+
+// 买单全部在前，各自按触发优先级排列
+func (tb *triggerBook) snapshot() []*protocol.Order {
+	return append(tb.buys.snapshot(), tb.sells.snapshot()...)
+}
+
+func isStopOrder(orderType protocol.OrderType) bool {
+	return orderType == protocol.TypeStopMarket || orderType == protocol.TypeStopLimit || isTrailingStop(orderType)
+}
+
+// 最新成交价是否触及触发价
+func isTriggered(order *protocol.Order, price udecimal.Decimal) bool {
+	if price.IsZero() {
+		return false
+	}
+	if order.Side == protocol.Buy {
+		return price.GreaterThanOrEqual(order.StopPrice)
+	}
+	return price.LessThanOrEqual(order.StopPrice)
+}
+
+// 止损单进入触发簿，最新价已触及时立即触发
+func (b *OrderBook) placeStopOrder(order *protocol.Order, logs *[]*OrderBookLog) {
+	if isTriggered(order, b.ticker.lastPrice) {
+		b.activateStop(order, logs)
+		return
+	}
+	b.triggers.add(order)
+	b.trackExpiry(order)
+	log := NewOpenLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, order.Timestamp)
+	log.StopPrice = order.StopPrice.String()
+	log.LeftSize = order.Size.String()
+	*logs = append(*logs, log)
+}
+
+// 止损单转为市价/限价单并撮合，未挂单时回收
+func (b *OrderBook) activateStop(order *protocol.Order, logs *[]*OrderBookLog) {
+	log := getOrderBookLog()
+	log.SeqId = b.seqId.Add(1)
+	log.Type = protocol.LogTypeTrigger
+	log.MarketId = b.marketId
+	log.Side = order.Side
+	log.Price = order.Price.String()
+	log.Size = order.Size.String()
+	log.OrderId = order.Id
+	log.UserId = order.UserId
+	log.OrderType = order.OrderType
+	log.StopPrice = order.StopPrice.String()
+	log.Timestamp = order.Timestamp
+	log.CreateTime = time.Now().UTC()
+	*logs = append(*logs, log)
+
+	if order.OrderType == protocol.TypeTrailingStopLimit {
+		order.Price = trailLimitPrice(order)
+	}
+	order.StopPrice = udecimal.Zero
+	limit := order.OrderType == protocol.TypeStopLimit || order.OrderType == protocol.TypeTrailingStopLimit
+	if limit && (order.Price.LessThanOrEqual(udecimal.Zero) || b.checkPriceBand(order.Price) != 0) {
+		order.OrderType = protocol.TypeLimit
+		cancel := NewCancelLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, order.Price, order.Size, order.OrderType, order.Timestamp)
+		cancel.RejectReason = protocol.ReasonPriceBand
+		*logs = append(*logs, cancel)
+		releaseOrder(order)
+		return
+	}
+	var matchLogs *[]*OrderBookLog
+	if !limit {
+		order.OrderType = protocol.TypeMarket
+		matchLogs = b.processMarketOrder(order, udecimal.Zero)
+	} else {
+		order.OrderType = protocol.TypeLimit
+		matchLogs = b.processLimitOrder(order)
+	}
+	*logs = append(*logs, *matchLogs...)
+	releaseLogSlice(matchLogs)
+	if exist, _ := b.findOrder(order.Id); exist != order {
+		releaseOrder(order)
+	}
+}
+
+// 成交价触发止损单并调整跟踪止损，触发产生的成交由 settleLogs 继续检查
+func (b *OrderBook) triggerStops(price udecimal.Decimal, timestamp int64, logs *[]*OrderBookLog) {
+	for b.triggers.count() > 0 {
+		order := b.triggers.popTriggered(price)
+		if order == nil {
+			break
+		}
+		order.Timestamp = timestamp
+		b.activateStop(order, logs)
+	}
+	b.adjustTrailingStops(price, timestamp, logs)
+}
+
+// 撤销未触发的止损单
+func (b *OrderBook) cancelStopOrder(order *protocol.Order, timestamp int64) {
+	logs := acquireLogSlice()
+	b.cancelTrigger(order, 0, timestamp, logs)
+	b.publishLogs(logs)
+}
+
+// 修改未触发的止损单，触发价不变且不加量时保留触发顺序
+func (b *OrderBook) amendStopOrder(order *protocol.Order, bean *protocol.AmendOrderCommand) {
+	//跟踪止损的触发价和限价由成交价决定，只能改数量
+	if isTrailingStop(order.OrderType) && len(bean.NewPrice) > 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidPrice, nil)
+		return
+	}
+	if isTrailingStop(order.OrderType) && len(bean.NewStopPrice) > 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidStopPrice, nil)
+		return
+	}
+	newPrice, newSize, newStop := order.Price, order.Size, order.StopPrice
+	if len(bean.NewPrice) > 0 {
+		price, err := udecimal.Parse(bean.NewPrice)
+		if err != nil || price.LessThanOrEqual(udecimal.Zero) || order.OrderType == protocol.TypeStopMarket {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidPrice, nil)
+			return
+		}
+		newPrice = price
+	}
+	if len(bean.NewSize) > 0 {
+		size, err := udecimal.Parse(bean.NewSize)
+		if err != nil || size.LessThan(b.lowSize) {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidSize, nil)
+			return
+		}
+		newSize = size
+	}
+	if len(bean.NewStopPrice) > 0 {
+		stop, err := udecimal.Parse(bean.NewStopPrice)
+		if err != nil || stop.LessThanOrEqual(udecimal.Zero) {
+			b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidStopPrice, nil)
+			return
+		}
+		newStop = stop
+	}
+	if newPrice.Equal(order.Price) && newSize.Equal(order.Size) && newStop.Equal(order.StopPrice) {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonAmendNoChange, nil)
+		return
+	}
+	if reason := b.config.checkOrder(newPrice, newSize, udecimal.Zero); reason != 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, reason, nil)
+		return
+	}
+	if reason := b.config.checkPrice(newStop); reason != 0 {
+		b.logRejectPayload(bean.OrderId, bean.UserId, protocol.ReasonInvalidStopPrice, nil)
+		return
+	}
+	oldPrice, oldSize := order.Price, order.Size
+	logs := acquireLogSlice()
+	log := NewAmendLog(b.seqId.Add(1), b.marketId, order.Id, order.UserId, order.Side, newPrice, newSize, oldPrice, oldSize, order.OrderType, bean.Timestamp)
+	log.StopPrice = newStop.String()
+	*logs = append(*logs, log)
+	order.Price = newPrice
+	order.Timestamp = bean.Timestamp
+	if newStop.Equal(order.StopPrice) && newSize.LessThanOrEqual(oldSize) {
+		order.Size = newSize
+	} else {
+		b.triggers.remove(order)
+		order.Size = newSize
+		order.StopPrice = newStop
+		if isTriggered(order, b.ticker.lastPrice) {
+			b.activateStop(order, logs)
+			b.publishLogs(logs)
+			return
+		}
+		b.triggers.add(order)
+	}
+	b.publishLogs(logs)
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"MOMEngine/protocol"
+	"bytes"
+	"testing"
+)
+
+func placeStop(t *testing.T, b *OrderBook, id string, side protocol.Side, orderType protocol.OrderType, stop, price, size string) {
+	t.Helper()
+	sendCmd(t, b, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: id, Side: side, OrderType: orderType, StopPrice: stop, Price: price, Size: size, UserId: 9,
+	})
+}
+
+// 成交触发止损单，触发后的成交继续触发下一个
+func TestOrderBook_StopCascade(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	tradeAt(t, b, "t0", "100", 1)
+	placeStop(t, b, "st1", protocol.Buy, protocol.TypeStopMarket, "102", "0", "1")
+	placeStop(t, b, "st2", protocol.Buy, protocol.TypeStopLimit, "104", "105", "1")
+	placeLimit(t, b, "a1", 1, protocol.Sell, "102", "1")
+	placeLimit(t, b, "a2", 1, protocol.Sell, "104", "1")
+	placeLimit(t, b, "a3", 1, protocol.Sell, "106", "1")
+	assertInt64(t, 2, b.triggers.count(), "untriggered stops")
+
+	before := len(memLog.GetLogs())
+	placeLimit(t, b, "b1", 2, protocol.Buy, "102", "1")
+	logs := memLog.GetLogs()[before:]
+	expected := []protocol.LogType{protocol.LogTypeMatch, protocol.LogTypeTrigger, protocol.LogTypeMatch, protocol.LogTypeTrigger, protocol.LogTypeOpen}
+	if len(logs) != len(expected) {
+		t.Fatalf("log count: expected %d, got %d", len(expected), len(logs))
+	}
+	for i, log := range logs {
+		if log.Type != expected[i] {
+			t.Errorf("log[%d] type: expected %d, got %d", i, expected[i], log.Type)
+		}
+	}
+	assertString(t, "st1", logs[1].OrderId, "first trigger")
+	assertString(t, "a2", logs[2].MakerOrderId, "stop market fill")
+	assertString(t, "st2", logs[3].OrderId, "cascaded trigger")
+	assertString(t, "105", b.bidQueue.PeakHeadOrder().Price.String(), "stop limit rests")
+	assertInt64(t, 0, b.triggers.count(), "all triggered")
+}
+
+// 未触发的止损单可以改单、撤单，并随快照保存
+func TestOrderBook_StopAmendCancelSnapshot(t *testing.T) {
+	b, memLog := newTestOrderBook()
+	tradeAt(t, b, "t0", "100", 1)
+	placeStop(t, b, "s1", protocol.Sell, protocol.TypeStopLimit, "95", "94", "2")
+	placeStop(t, b, "s2", protocol.Sell, protocol.TypeStopMarket, "90", "0", "1")
+	placeStop(t, b, "bad", protocol.Sell, protocol.TypeStopMarket, "0", "0", "1")
+	logs := memLog.GetLogs()
+	assertInt64(t, protocol.ReasonInvalidStopPrice, int64(logs[len(logs)-1].RejectReason), "invalid stop price")
+
+	sendCmd(t, b, protocol.CmdAmendOrder, &protocol.AmendOrderCommand{OrderId: "s2", UserId: 9, NewStopPrice: "96"})
+	assertString(t, "s2", b.triggers.sells.head().Id, "amended stop moves ahead")
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, b.takeSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreOrderBook(&buf, NewMemoryLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameOrders(t, b.triggers.snapshot(), restored.triggers.snapshot(), "restored stops")
+	assertString(t, "96", restored.triggers.get("s2").StopPrice.String(), "restored stop price")
+
+	sendCmd(t, b, protocol.CmdCancelOrder, &protocol.CancelOrderCommand{OrderId: "s1", UserId: 9})
+	logs = memLog.GetLogs()
+	assertInt64(t, int64(protocol.LogTypeCancel), int64(logs[len(logs)-1].Type), "stop cancelled")
+	assertInt64(t, 1, b.triggers.count(), "remaining stops")
+}
@@ -1 +1,331 @@
 package MOMEngine
+
+import (
+	"MOMEngine/core"
+	"MOMEngine/protocol"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/quagmt/udecimal"
+)
+
+var (
+	ErrMarketExists   = errors.New("market already exists")
+	ErrMarketNotFound = errors.New("market not found")
+	ErrEngineShutdown = errors.New("engine is shutting down")
+)
+
+// 撮合引擎，按交易对管理多个订单簿
+type Engine struct {
+	mu         sync.RWMutex
+	books      map[string]*core.OrderBook //交易对ID -> 订单簿
+	tradeLog   core.PushLog
+	serializer protocol.Serializer
+	opts       []core.OrderBookOption //所有订单簿共用的配置
+	journal    core.Journal           //创建交易对指令日志，为空时不落盘
+	started    atomic.Bool
+	shutDown   atomic.Bool
+}
+
+func NewEngine(tradeLog core.PushLog, opts ...core.OrderBookOption) *Engine {
+	return &Engine{
+		books:      make(map[string]*core.OrderBook),
+		tradeLog:   tradeLog,
+		serializer: &protocol.DefaultSerializer{},
+		opts:       opts,
+	}
+}
+
+// 启动所有订单簿
+func (e *Engine) Start() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.started.Swap(true) {
+		return
+	}
+	for _, book := range e.books {
+		book.Start()
+	}
+}
+
+// 停止接收指令，等待所有订单簿处理完剩余指令
+// 持写锁标记停止，之后创建交易对一定失败，不会漏掉新订单簿
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	e.shutDown.Store(true)
+	books := make([]*core.OrderBook, 0, len(e.books))
+	for _, book := range e.books {
+		books = append(books, book)
+	}
+	e.mu.Unlock()
+	var errs []error
+	for _, book := range books {
+		if err := book.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 获取订单簿
+func (e *Engine) OrderBook(marketId string) *core.OrderBook {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.books[marketId]
+}
+
+// 所有交易对
+func (e *Engine) Markets() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	markets := make([]string, 0, len(e.books))
+	for marketId := range e.books {
+		markets = append(markets, marketId)
+	}
+	return markets
+}
+
+// 设置创建交易对的指令日志，需在创建交易对之前调用
+// 应与订单簿 WithJournal 使用同一个日志，恢复时按日志中的创建指令重建订单簿
+func (e *Engine) SetJournal(journal core.Journal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.journal = journal
+}
+
+// 创建交易对，引擎已启动时新订单簿立即启动
+func (e *Engine) CreateMarket(cmd *protocol.CreateMarketCommand) (*core.OrderBook, error) {
+	return e.createMarket(cmd, nil)
+}
+
+// raw 为上游原始指令，为空时按 cmd 生成日志记录
+func (e *Engine) createMarket(cmd *protocol.CreateMarketCommand, raw *protocol.Command) (*core.OrderBook, error) {
+	if e.shutDown.Load() {
+		return nil, ErrEngineShutdown
+	}
+	opts, err := e.marketOptions(cmd)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shutDown.Load() {
+		return nil, ErrEngineShutdown
+	}
+	if _, ok := e.books[cmd.MarketId]; ok {
+		return nil, ErrMarketExists
+	}
+	if e.journal != nil {
+		if raw == nil {
+			payload, err := e.serializer.Marshal(cmd)
+			if err != nil {
+				return nil, err
+			}
+			raw = &protocol.Command{MarketId: cmd.MarketId, Type: protocol.CmdCreateMarket, Payload: payload}
+		}
+		//先落盘再创建，订单簿的指令日志总在创建指令之后
+		if err := e.journal.Append(raw); err != nil {
+			return nil, err
+		}
+		if err := e.journal.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	book := core.NewOrderBook(cmd.MarketId, e.tradeLog, opts...)
+	e.books[cmd.MarketId] = book
+	if e.started.Load() {
+		book.Start()
+	}
+	return book, nil
+}
+
+// 交易对配置，引擎公共配置在前
+func (e *Engine) marketOptions(cmd *protocol.CreateMarketCommand) ([]core.OrderBookOption, error) {
+	if len(cmd.MarketId) == 0 {
+		return nil, errors.New("invalid market id")
+	}
+	opts := make([]core.OrderBookOption, 0, len(e.opts)+3)
+	opts = append(opts, e.opts...)
+	if len(cmd.MinLotSize) > 0 {
+		lowSize, err := udecimal.Parse(cmd.MinLotSize)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, core.WithLowSize(lowSize))
+	}
+	if cmd.STPMode != protocol.STPNone {
+		opts = append(opts, core.WithSTPMode(cmd.STPMode))
+	}
+	config, err := core.DefaultMarketConfig().Apply(&cmd.TradingRules)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, core.WithMarketConfig(config)), nil
+}
+
+// 解析创建交易对指令，payload 未指定交易对时使用 Command.MarketId
+func (e *Engine) decodeCreateMarket(cmd *protocol.Command) (*protocol.CreateMarketCommand, error) {
+	payload := &protocol.CreateMarketCommand{}
+	if err := e.serializer.Unmarshal(cmd.Payload, &payload); err != nil {
+		return nil, err
+	}
+	if len(payload.MarketId) == 0 {
+		payload.MarketId = cmd.MarketId
+	}
+	return payload, nil
+}
+
+// 从指令日志恢复引擎：按日志中的创建指令重建交易对，各订单簿再从快照和日志重放
+// snapshots 按交易对ID索引，缺少快照的交易对从头重放；只有快照的交易对按快照恢复
+// published 为各交易对下游已收到的最大日志ID，之后的日志在重放时补推，缺少的交易对视为未推送过
+// opts 与 NewEngine 相同，恢复后的引擎未启动，需调用 SetJournal 后再 Start
+func RecoverEngine(tradeLog core.PushLog, journalDir string, snapshots map[string]*core.BookSnapshot, published map[string]int64, opts ...core.OrderBookOption) (*Engine, error) {
+	e := NewEngine(tradeLog, opts...)
+	markets := make([]*protocol.CreateMarketCommand, 0)
+	seen := make(map[string]bool)
+	if len(journalDir) > 0 {
+		err := core.ReadJournal(journalDir, func(cmd *protocol.Command) error {
+			if cmd.Type != protocol.CmdCreateMarket {
+				return nil
+			}
+			payload, err := e.decodeCreateMarket(cmd)
+			if err != nil {
+				return err
+			}
+			if !seen[payload.MarketId] {
+				seen[payload.MarketId] = true
+				markets = append(markets, payload)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for marketId := range snapshots {
+		if !seen[marketId] {
+			markets = append(markets, &protocol.CreateMarketCommand{MarketId: marketId})
+		}
+	}
+	for _, market := range markets {
+		bookOpts, err := e.marketOptions(market)
+		if err != nil {
+			return nil, err
+		}
+		book, err := core.RecoverOrderBook(market.MarketId, tradeLog, snapshots[market.MarketId], journalDir, published[market.MarketId], bookOpts...)
+		if err != nil {
+			return nil, err
+		}
+		e.books[market.MarketId] = book
+	}
+	return e, nil
+}
+
+// 按 Command.MarketId 转发指令，创建交易对在引擎内直接处理
+func (e *Engine) Submit(cmd *protocol.Command) error {
+	return e.SubmitWithAck(cmd, nil)
+}
+
+// 转发指令，处理完成后回调 ack，ack 为空时不回调
+// 创建交易对在引擎内同步处理，ack 在调用方协程回调，其余指令的 ack 在撮合线程回调
+func (e *Engine) SubmitWithAck(cmd *protocol.Command, ack func(*protocol.CommandResult)) error {
+	if e.shutDown.Load() {
+		return ErrEngineShutdown
+	}
+	if cmd.Type == protocol.CmdCreateMarket {
+		payload, err := e.decodeCreateMarket(cmd)
+		if err != nil {
+			return err
+		}
+		if _, err := e.createMarket(payload, cmd); err != nil {
+			return err
+		}
+		if ack != nil {
+			ack(&protocol.CommandResult{CmdSeqId: cmd.SeqId, Accepted: true})
+		}
+		return nil
+	}
+	book := e.OrderBook(cmd.MarketId)
+	if book == nil {
+		return ErrMarketNotFound
+	}
+	if ack != nil {
+		return book.EnqueueCommandWithAck(cmd, ack)
+	}
+	return book.EnqueueCommand(cmd)
+}
+
+// 查询深度
+func (e *Engine) GetDepth(ctx context.Context, req *protocol.RequestGetDepth) (*protocol.DepthResponse, error) {
+	book := e.OrderBook(req.MarketId)
+	if book == nil {
+		return nil, ErrMarketNotFound
+	}
+	return book.GetDepth(ctx, req)
+}
+
+// 查询交易对状态
+func (e *Engine) GetState(ctx context.Context, req *protocol.RequestGetState) (*protocol.StateResponse, error) {
+	book := e.OrderBook(req.MarketId)
+	if book == nil {
+		return nil, ErrMarketNotFound
+	}
+	return book.GetState(ctx, req)
+}
+
+// 查询24小时行情
+func (e *Engine) GetTicker(ctx context.Context, req *protocol.RequestGetTicker) (*protocol.TickerResponse, error) {
+	book := e.OrderBook(req.MarketId)
+	if book == nil {
+		return nil, ErrMarketNotFound
+	}
+	return book.GetTicker(ctx, req)
+}
+
+// 向所有交易对发送定时指令，驱动到期撤单，时间由调用方提供以保证重放一致
+func (e *Engine) Tick(timestamp int64) error {
+	if e.shutDown.Load() {
+		return ErrEngineShutdown
+	}
+	payload, err := e.serializer.Marshal(&protocol.TimerCommand{Timestamp: timestamp})
+	if err != nil {
+		return err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var errs []error
+	for marketId, book := range e.books {
+		cmd := &protocol.Command{MarketId: marketId, Type: protocol.CmdTimer, Payload: payload}
+		if err := book.EnqueueCommand(cmd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 向所有交易对发送心跳，session 不为空时只保护该会话下的订单
+func (e *Engine) Heartbeat(hb *protocol.HeartbeatCommand, session string) error {
+	if e.shutDown.Load() {
+		return ErrEngineShutdown
+	}
+	payload, err := e.serializer.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	var metadata map[string]string
+	if len(session) > 0 {
+		metadata = map[string]string{protocol.MetaSession: session}
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var errs []error
+	for marketId, book := range e.books {
+		cmd := &protocol.Command{MarketId: marketId, Type: protocol.CmdHeartbeat, Payload: payload, Metadata: metadata}
+		if err := book.EnqueueCommand(cmd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
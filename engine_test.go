@@ -0,0 +1,250 @@
+package MOMEngine
+
+import (
+	"MOMEngine/core"
+	"MOMEngine/protocol"
+	"context"
+	"testing"
+	"time"
+)
+
+func mustCommand(t *testing.T, marketId string, cmdType protocol.CommandType, payload any) *protocol.Command {
+	t.Helper()
+	bs, err := (&protocol.DefaultSerializer{}).Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	return &protocol.Command{MarketId: marketId, Type: cmdType, Payload: bs}
+}
+
+// 多交易对路由与启动/停止
+func TestEngine_RouteAndShutdown(t *testing.T) {
+	memLog := core.NewMemoryLog()
+	engine := NewEngine(memLog)
+	engine.Start()
+
+	for _, marketId := range []string{"BTC-USDT", "ETH-USDT"} {
+		cmd := mustCommand(t, marketId, protocol.CmdCreateMarket, &protocol.CreateMarketCommand{MarketId: marketId})
+		if err := engine.Submit(cmd); err != nil {
+			t.Fatalf("create market %s: %v", marketId, err)
+		}
+	}
+	if err := engine.Submit(mustCommand(t, "BTC-USDT", protocol.CmdCreateMarket, &protocol.CreateMarketCommand{})); err != ErrMarketExists {
+		t.Fatalf("expected ErrMarketExists, got %v", err)
+	}
+	if err := engine.Submit(mustCommand(t, "DOGE-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{})); err != ErrMarketNotFound {
+		t.Fatalf("expected ErrMarketNotFound, got %v", err)
+	}
+
+	place := func(marketId, id string, side protocol.Side) {
+		cmd := mustCommand(t, marketId, protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: id, Side: side, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1,
+		})
+		if err := engine.Submit(cmd); err != nil {
+			t.Fatalf("submit %s: %v", id, err)
+		}
+	}
+	place("BTC-USDT", "b1", protocol.Buy)
+	place("ETH-USDT", "e1", protocol.Sell)
+	place("BTC-USDT", "b2", protocol.Sell)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := engine.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := engine.Submit(mustCommand(t, "BTC-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{})); err != ErrEngineShutdown {
+		t.Fatalf("expected ErrEngineShutdown, got %v", err)
+	}
+	if _, err := engine.CreateMarket(&protocol.CreateMarketCommand{MarketId: "SOL-USDT"}); err != ErrEngineShutdown {
+		t.Fatalf("expected ErrEngineShutdown on create, got %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, log := range memLog.GetLogs() {
+		counts[log.MarketId]++
+	}
+	if counts["BTC-USDT"] != 2 || counts["ETH-USDT"] != 1 {
+		t.Fatalf("unexpected log counts %v", counts)
+	}
+}
+
+// 创建交易对时带交易规则
+func TestEngine_CreateMarketTradingRules(t *testing.T) {
+	engine := NewEngine(core.NewMemoryLog())
+	engine.Start()
+	defer engine.Shutdown(context.Background())
+
+	cmd := mustCommand(t, "BTC-USDT", protocol.CmdCreateMarket, &protocol.CreateMarketCommand{
+		TradingRules: protocol.TradingRules{TickSize: "0.5"},
+	})
+	if err := engine.Submit(cmd); err != nil {
+		t.Fatalf("create market: %v", err)
+	}
+	results := make(chan *protocol.CommandResult, 1)
+	cmd = mustCommand(t, "BTC-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "o1", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100.2", Size: "1", UserId: 1,
+	})
+	if err := engine.SubmitWithAck(cmd, func(result *protocol.CommandResult) { results <- result }); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	select {
+	case result := <-results:
+		if result.Accepted || result.RejectReason != protocol.ReasonInvalidTick {
+			t.Fatalf("expected tick reject, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ack timeout")
+	}
+
+	bad := mustCommand(t, "ETH-USDT", protocol.CmdCreateMarket, &protocol.CreateMarketCommand{
+		TradingRules: protocol.TradingRules{TickSize: "-1"},
+	})
+	if err := engine.Submit(bad); err != core.ErrInvalidMarketConfig {
+		t.Fatalf("expected ErrInvalidMarketConfig, got %v", err)
+	}
+}
+
+// 定时指令经 RingBuffer 驱动到期撤单
+func TestEngine_TickExpiresOrders(t *testing.T) {
+	memLog := core.NewMemoryLog()
+	engine := NewEngine(memLog)
+	engine.Start()
+	if err := engine.Submit(mustCommand(t, "BTC-USDT", protocol.CmdCreateMarket, &protocol.CreateMarketCommand{})); err != nil {
+		t.Fatalf("create market: %v", err)
+	}
+	cmd := mustCommand(t, "BTC-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "o1", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1,
+		TimeInForce: protocol.TifGTD, ExpireTime: 60, Timestamp: 1,
+	})
+	if err := engine.Submit(cmd); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if err := engine.Tick(60); err != nil {
+		t.Fatalf("tick: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := engine.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	logs := memLog.GetLogs()
+	last := logs[len(logs)-1]
+	if last.Type != protocol.LogTypeCancel || last.RejectReason != protocol.ReasonExpired {
+		t.Fatalf("expected expiry cancel, got %+v", last)
+	}
+}
+
+func TestEngine_HeartbeatCancelsSession(t *testing.T) {
+	memLog := core.NewMemoryLog()
+	engine := NewEngine(memLog)
+	engine.Start()
+	if err := engine.Submit(mustCommand(t, "BTC-USDT", protocol.CmdCreateMarket, &protocol.CreateMarketCommand{})); err != nil {
+		t.Fatalf("create market: %v", err)
+	}
+	for _, session := range []string{"s1", "s2"} {
+		cmd := mustCommand(t, "BTC-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: session, Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100", Size: "1", UserId: 1,
+		})
+		cmd.Metadata = map[string]string{protocol.MetaSession: session}
+		if err := engine.Submit(cmd); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	if err := engine.Heartbeat(&protocol.HeartbeatCommand{UserId: 1, Timeout: 10, Timestamp: 0}, "s1"); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if err := engine.Tick(10); err != nil {
+		t.Fatalf("tick: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := engine.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	logs := memLog.GetLogs()
+	last := logs[len(logs)-1]
+	if last.Type != protocol.LogTypeCancel || last.RejectReason != protocol.ReasonHeartbeatTimeout || last.OrderId != "s1" {
+		t.Fatalf("expected heartbeat cancel of s1, got %+v", last)
+	}
+}
+
+// 创建交易对指令写入日志，崩溃后按日志重建交易对和订单
+func TestEngine_RecoverMarkets(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := core.OpenFileJournal(core.FileJournalConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine(core.NewMemoryLog(), core.WithJournal(journal))
+	engine.SetJournal(journal)
+	engine.Start()
+	create := mustCommand(t, "BTC-USDT", protocol.CmdCreateMarket, &protocol.CreateMarketCommand{
+		TradingRules: protocol.TradingRules{TickSize: "0.5"},
+	})
+	if err := engine.Submit(create); err != nil {
+		t.Fatalf("create market: %v", err)
+	}
+	if _, err := engine.CreateMarket(&protocol.CreateMarketCommand{MarketId: "ETH-USDT"}); err != nil {
+		t.Fatalf("create market: %v", err)
+	}
+	for _, cmd := range []*protocol.Command{
+		mustCommand(t, "BTC-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: "b1", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100.5", Size: "1", UserId: 1,
+		}),
+		mustCommand(t, "ETH-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+			OrderId: "e1", Side: protocol.Sell, OrderType: protocol.TypeLimit, Price: "10", Size: "2", UserId: 1,
+		}),
+	} {
+		if err := engine.Submit(cmd); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := engine.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	journal.Close()
+
+	recovered, err := RecoverEngine(core.NewMemoryLog(), dir, nil, nil)
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if len(recovered.Markets()) != 2 {
+		t.Fatalf("expected 2 markets, got %v", recovered.Markets())
+	}
+	depth, err := recovered.GetDepth(context.Background(), &protocol.RequestGetDepth{MarketId: "BTC-USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depth.Bids) != 1 || depth.Bids[0].Price.String() != "100.5" {
+		t.Fatalf("unexpected BTC depth %+v", depth.Bids)
+	}
+	depth, err = recovered.GetDepth(context.Background(), &protocol.RequestGetDepth{MarketId: "ETH-USDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(depth.Asks) != 1 || depth.Asks[0].Size.String() != "2" {
+		t.Fatalf("unexpected ETH depth %+v", depth.Asks)
+	}
+
+	//恢复后的交易对保留创建时的交易规则
+	recovered.Start()
+	defer recovered.Shutdown(context.Background())
+	results := make(chan *protocol.CommandResult, 1)
+	cmd := mustCommand(t, "BTC-USDT", protocol.CmdPlaceOrder, &protocol.PlaceOrderCommand{
+		OrderId: "b2", Side: protocol.Buy, OrderType: protocol.TypeLimit, Price: "100.2", Size: "1", UserId: 1,
+	})
+	if err := recovered.SubmitWithAck(cmd, func(result *protocol.CommandResult) { results <- result }); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	select {
+	case result := <-results:
+		if result.Accepted || result.RejectReason != protocol.ReasonInvalidTick {
+			t.Fatalf("expected tick reject, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ack timeout")
+	}
+}
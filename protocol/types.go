@@ -19,9 +19,44 @@ const (
 	TypeMarket   OrderType = "market"
 	TypeLimit    OrderType = "limit"
 	TypeFOK      OrderType = "fok"
-	typeIOC      OrderType = "ioc"
+	TypeIOC      OrderType = "ioc"
 	TypePostOnly OrderType = "postOnly"
 	TypeCancel   OrderType = "cancel"
+
+	TypeStopMarket OrderType = "stopMarket" //最新成交价触及触发价后转为市价单
+	TypeStopLimit  OrderType = "stopLimit"  //最新成交价触及触发价后转为限价单
+
+	TypeTrailingStop      OrderType = "trailingStop"      //跟踪止损，触发后转为市价单
+	TypeTrailingStopLimit OrderType = "trailingStopLimit" //跟踪止损，触发后按触发价加减 LimitOffset 转为限价单
+)
+
+// 有效期
+type TimeInForce string
+
+const (
+	TifGTC TimeInForce = "gtc" //撤单前一直有效，默认
+	TifGTD TimeInForce = "gtd" //到 ExpireTime 失效
+	TifDAY TimeInForce = "day" //到下单时间(UTC)当天结束失效
+)
+
+// 订单组角色，同一 GroupId 的订单联动
+type GroupRole string
+
+const (
+	GroupOCO   GroupRole = "oco"   //二选一，任一腿成交或撤销后撤销其余腿
+	GroupEntry GroupRole = "entry" //括号单主单
+	GroupExit  GroupRole = "exit"  //括号单子单，主单成交后按成交量激活，子单之间二选一
+)
+
+// 自成交防护模式，taker 与同一用户的挂单相遇时生效
+type STPMode uint8
+
+const (
+	STPNone            STPMode = 0 //不检查，允许自成交
+	STPCancelNewest    STPMode = 1 //撤销新单剩余部分
+	STPCancelOldest    STPMode = 2 //撤销挂单，新单继续撮合
+	STPCancelBoth      STPMode = 3 //双方都撤销
+	STPDecrementCancel STPMode = 4 //双方扣减较小数量，数量较小的一方撤销
 )
 
 type Order struct {
@@ -35,6 +70,15 @@ type Order struct {
 
 	VisibleLimit udecimal.Decimal `json:"visibleLimit"`
 	HiddenSize   udecimal.Decimal `json:"hiddenSize"`
+	STPMode      STPMode          `json:"stpMode"`   //下单时确定的自成交防护模式
+	StopPrice    udecimal.Decimal `json:"stopPrice"` //止损触发价，触发后清零
+	//跟踪止损，TrailAmount 和 TrailPercent 只有一个非零
+	TrailAmount  udecimal.Decimal `json:"trailAmount"`
+	TrailPercent udecimal.Decimal `json:"trailPercent"`
+	TrailExtreme udecimal.Decimal `json:"trailExtreme"` //下单以来的最高价(卖)或最低价(买)
+	LimitOffset  udecimal.Decimal `json:"limitOffset"`
+	ExpireAt     int64            `json:"expireAt"` //失效时间(秒)，0 表示不失效
+	Session      string           `json:"session"`  //下单会话，心跳超时时按会话撤单
 
 	Prev *Order
 	Next *Order
@@ -46,6 +90,9 @@ type OrderDepth struct {
 	Count int64
 }
 
+// Command.Metadata 中的会话ID，订单和心跳按会话关联
+const MetaSession = "session"
+
 type Command struct {
 	Version  uint8             `json:"version"`  //版本
 	MarketId string            `json:"marketId"` //交易对ID
@@ -53,6 +100,8 @@ type Command struct {
 	Type     CommandType       `json:"type"`     // 指令类型
 	Payload  []byte            `json:"payload"`  //负荷
 	Metadata map[string]string `json:"metadata"` //元数据
+	//未指定 SeqId 的指令(定时、心跳等)由订单簿按本地顺序编号，用于重放去重
+	LocalSeqId int64 `json:"localSeqId"`
 }
 
 type CommandType uint8
@@ -67,6 +116,10 @@ const (
 	CmdPlaceOrder  CommandType = 10
 	CmdCancelOrder CommandType = 11
 	CmdAmendOrder  CommandType = 12
+	CmdMassCancel  CommandType = 13
+	CmdHeartbeat   CommandType = 14
+
+	CmdTimer CommandType = 20 //定时指令，携带外部时钟驱动到期处理
 )
 
 type OrderBookState uint8
@@ -79,15 +132,24 @@ const (
 
 // 指令：挂单
 type PlaceOrderCommand struct {
-	OrderId      string    `json:"orderId"`
-	Side         Side      `json:"side"`
-	OrderType    OrderType `json:"orderType"`
-	Price        string    `json:"price"`
-	Size         string    `json:"size"`
-	VisibleLimit string    `json:"visibleLimit"`
-	QuoteSize    string    `json:"quoteSize"`
-	UserId       int64     `json:"userId"`
-	Timestamp    int64     `json:"timestamp"`
+	OrderId      string      `json:"orderId"`
+	Side         Side        `json:"side"`
+	OrderType    OrderType   `json:"orderType"`
+	Price        string      `json:"price"`
+	Size         string      `json:"size"`
+	VisibleLimit string      `json:"visibleLimit"`
+	QuoteSize    string      `json:"quoteSize"`
+	UserId       int64       `json:"userId"`
+	Timestamp    int64       `json:"timestamp"`
+	STPMode      STPMode     `json:"stpMode"`      //为 0 时使用交易对配置
+	StopPrice    string      `json:"stopPrice"`    //止损触发价，买单最新价>=触发价时触发，卖单<=时触发
+	TrailAmount  string      `json:"trailAmount"`  //跟踪距离，按固定价差
+	TrailPercent string      `json:"trailPercent"` //跟踪距离，按百分比
+	LimitOffset  string      `json:"limitOffset"`  //跟踪止损限价单的限价相对触发价的偏移
+	TimeInForce  TimeInForce `json:"timeInForce"`  //为空时按 GTC
+	ExpireTime   int64       `json:"expireTime"`   //GTD 失效时间(秒)
+	GroupId      string      `json:"groupId"`      //订单组ID，为空时不分组
+	GroupRole    GroupRole   `json:"groupRole"`
 }
 
 // 指令：取消订单
@@ -99,18 +161,70 @@ type CancelOrderCommand struct {
 
 // 指令：调整挂单价格和数量
 type AmendOrderCommand struct {
-	OrderId   string `json:"orderId"`   //订单ID
-	UserId    int64  `json:"userId"`    //用户ID
-	NewPrice  string `json:"newPrice"`  //新价格
-	NewSize   string `json:"newSize"`   //新数量
-	Timestamp int64  `json:"timestamp"` //时间戳
+	OrderId      string `json:"orderId"`      //订单ID
+	UserId       int64  `json:"userId"`       //用户ID
+	NewPrice     string `json:"newPrice"`     //新价格
+	NewSize      string `json:"newSize"`      //新数量
+	NewStopPrice string `json:"newStopPrice"` //新触发价，只适用于未触发的止损单
+	Timestamp    int64  `json:"timestamp"`    //时间戳
+}
+
+// 指令：批量撤单，撤销用户在交易对的全部订单(含未触发的止损单)，可按方向、价格区间过滤
+type MassCancelCommand struct {
+	UserId    int64  `json:"userId"`
+	All       bool   `json:"all"`      //撤销全部用户的订单，管理员使用，忽略 UserId
+	Side      Side   `json:"side"`     //为 0 时不限方向
+	MinPrice  string `json:"minPrice"` //价格下限(含)，为空时不限；止损单按触发价过滤
+	MaxPrice  string `json:"maxPrice"` //价格上限(含)，为空时不限
+	Timestamp int64  `json:"timestamp"`
+}
+
+// 指令：心跳，超时未收到下一次心跳时撤销该会话的全部订单
+// 会话ID取自 Command.Metadata[MetaSession]，为空时撤销该用户的全部订单
+type HeartbeatCommand struct {
+	UserId    int64 `json:"userId"`
+	Timeout   int64 `json:"timeout"` //超时时间(秒)，为 0 时使用交易对默认值
+	Disable   bool  `json:"disable"` //关闭保护
+	Timestamp int64 `json:"timestamp"`
+}
+
+// 价格带参考价
+type BandReference uint8
+
+const (
+	BandRefLastTrade BandReference = 0 //最新成交价
+	BandRefMid       BandReference = 1 //买一卖一中间价，单边无挂单时取最新成交价
+)
+
+// 指令：定时，Timestamp 之前(含)到期的订单被撤销
+type TimerCommand struct {
+	Timestamp int64 `json:"timestamp"`
+}
+
+// 交易规则，字符串为空表示不设置(更新时不修改)，"0" 表示不限制
+type TradingRules struct {
+	TickSize      string `json:"tickSize"`                //价格最小变动单位
+	StepSize      string `json:"stepSize"`                //数量最小变动单位
+	MinSize       string `json:"minSize"`                 //单笔最小数量
+	MaxSize       string `json:"maxSize"`                 //单笔最大数量
+	MinNotional   string `json:"minNotional"`             //单笔最小金额
+	MaxNotional   string `json:"maxNotional"`             //单笔最大金额
+	PriceDecimals *int32 `json:"priceDecimals,omitempty"` //价格最大小数位，-1 不限制
+	SizeDecimals  *int32 `json:"sizeDecimals,omitempty"`  //数量最大小数位，-1 不限制
+
+	PriceBand      string         `json:"priceBand"`               //限价偏离参考价的最大百分比
+	BandReference  *BandReference `json:"bandReference,omitempty"` //价格带参考价
+	BreakerPercent string         `json:"breakerPercent"`          //窗口内价格波动超过该百分比时熔断
+	BreakerWindow  *int64         `json:"breakerWindow,omitempty"` //熔断统计窗口(秒)
 }
 
 // 指令：创建交易对
 type CreateMarketCommand struct {
-	UserId     int64  `json:"userId"`
-	MarketId   string `json:"marketId"`
-	MinLotSize string `json:"minLotSize"`
+	UserId     int64   `json:"userId"`
+	MarketId   string  `json:"marketId"`
+	MinLotSize string  `json:"minLotSize"`
+	STPMode    STPMode `json:"stpMode"` //默认自成交防护模式
+	TradingRules
 }
 
 // 指令：暂停
@@ -128,9 +242,11 @@ type ResumeMarketCommand struct {
 
 // 指令：更新配置
 type UpdateConfigCommand struct {
-	UserId     int64  `json:"userId"`
-	MarketId   string `json:"marketId"`
-	MinLotSize string `json:"minLotSize"`
+	UserId     int64    `json:"userId"`
+	MarketId   string   `json:"marketId"`
+	MinLotSize string   `json:"minLotSize"`
+	STPMode    *STPMode `json:"stpMode,omitempty"` //为空时不修改
+	TradingRules
 }
 
 // request
@@ -142,27 +258,134 @@ type RequestGetState struct {
 	MarketId string `json:"marketId"`
 }
 
+type RequestGetTicker struct {
+	MarketId  string `json:"marketId"`
+	Timestamp int64  `json:"timestamp"` //统计截止时间(秒)，为0时取最后成交时间
+}
+
+// response
+type DepthResponse struct {
+	MarketId     string        `json:"marketId"`
+	Bids         []*OrderDepth `json:"bids"`
+	Asks         []*OrderDepth `json:"asks"`
+	SeqId        int64         `json:"seqId"`        //最后一条日志ID
+	LastCmdSeqId int64         `json:"lastCmdSeqId"` //最后处理的指令ID
+	//最后处理的本地编号指令ID，增量行情按 CmdSeqId 或 LocalSeqId 各自对齐
+	LastLocalSeqId int64 `json:"lastLocalSeqId"`
+}
+type StateResponse struct {
+	MarketId       string         `json:"marketId"`
+	State          OrderBookState `json:"state"`
+	BidOrders      int64          `json:"bidOrders"`  //买单数量
+	AskOrders      int64          `json:"askOrders"`  //卖单数量
+	BidDepth       int64          `json:"bidDepth"`   //买单价格档位数
+	AskDepth       int64          `json:"askDepth"`   //卖单价格档位数
+	StopOrders     int64          `json:"stopOrders"` //未触发的止损单数量
+	SeqId          int64          `json:"seqId"`
+	TradeId        int64          `json:"tradeId"`
+	LastCmdSeqId   int64          `json:"lastCmdSeqId"`
+	LastLocalSeqId int64          `json:"lastLocalSeqId"`
+}
+
+// 24小时行情统计
+type TickerResponse struct {
+	MarketId           string           `json:"marketId"`
+	LastPrice          udecimal.Decimal `json:"lastPrice"`
+	BestBid            udecimal.Decimal `json:"bestBid"`
+	BestBidSize        udecimal.Decimal `json:"bestBidSize"`
+	BestAsk            udecimal.Decimal `json:"bestAsk"`
+	BestAskSize        udecimal.Decimal `json:"bestAskSize"`
+	Open               udecimal.Decimal `json:"open"` //24小时内第一笔成交价
+	High               udecimal.Decimal `json:"high"`
+	Low                udecimal.Decimal `json:"low"`
+	Volume             udecimal.Decimal `json:"volume"`
+	QuoteVolume        udecimal.Decimal `json:"quoteVolume"`
+	PriceChange        udecimal.Decimal `json:"priceChange"`
+	PriceChangePercent udecimal.Decimal `json:"priceChangePercent"`
+	Vwap               udecimal.Decimal `json:"vwap"`
+	TradeCount         int64            `json:"tradeCount"`
+	Timestamp          int64            `json:"timestamp"` //统计截止时间
+}
+
 type InputEvent struct {
-	Cmd *Command
+	Cmd  *Command
+	Ack  func(*CommandResult) //指令处理结果回调，在撮合线程调用
+	Task func()               //在撮合线程执行的内部任务，如快照
+}
+
+// 成交明细
+type Fill struct {
+	TradeId      int64  `json:"tradeId"`
+	Price        string `json:"price"`
+	Size         string `json:"size"`
+	Amount       string `json:"amount"`
+	MakerOrderId string `json:"makerOrderId"`
+	MakerUserId  int64  `json:"makerUserId"`
+}
+
+// 指令处理结果
+type CommandResult struct {
+	CmdSeqId       int64  `json:"cmdSeqId"`
+	OrderId        string `json:"orderId"`
+	Accepted       bool   `json:"accepted"`       //指令是否生效，部分成交后剩余被拒绝仍视为生效
+	RejectReason   int32  `json:"rejectReason"`   //拒绝原因
+	Fills          []Fill `json:"fills"`          //作为 taker 的成交
+	FilledSize     string `json:"filledSize"`     //累计成交数量
+	RestingSize    string `json:"restingSize"`    //挂单剩余数量，含冰山隐藏部分
+	CancelledSize  string `json:"cancelledSize"`  //撤销数量
+	CancelledCount int64  `json:"cancelledCount"` //撤销订单数，批量撤单时为全部撤销的订单数
 }
 
 type LogType uint8
 
 const (
-	LogTypeOpen   LogType = 0
-	LogTypeMatch  LogType = 1
-	LogTypeCancel LogType = 2
-	LogTypeAmend  LogType = 3
-	LogTypeReject LogType = 4
+	LogTypeOpen       LogType = 0
+	LogTypeMatch      LogType = 1
+	LogTypeCancel     LogType = 2
+	LogTypeAmend      LogType = 3
+	LogTypeReject     LogType = 4
+	LogTypeState      LogType = 5 //交易对状态变更
+	LogTypeTrigger    LogType = 6 //止损单触发
+	LogTypeStopUpdate LogType = 7 //跟踪止损调整触发价
 )
 
 type ReasonCode int32
 
 const (
-	ReasonUnknown          ReasonCode = -1
-	ReasonInvalidPayload              = 100
-	ReasonStateHadDone                = 101
-	ReasonDuplicateOrderID            = 102
-	ReasonNoLiquidity                 = 103
-	ReasonLowSize                     = 104
+	ReasonUnknown            ReasonCode = -1
+	ReasonInvalidPayload                = 100
+	ReasonStateHadDone                  = 101
+	ReasonDuplicateOrderID              = 102
+	ReasonNoLiquidity                   = 103
+	ReasonLowSize                       = 104
+	ReasonOrderNotFound                 = 105
+	ReasonNotOrderOwner                 = 106
+	ReasonInvalidPrice                  = 107
+	ReasonInvalidSize                   = 108
+	ReasonAmendNoChange                 = 109
+	ReasonInvalidOrderType              = 110
+	ReasonFOKNotFilled                  = 111
+	ReasonPostOnlyWouldCross            = 112
+	ReasonIOCRemainder                  = 113
+	ReasonJournalFailed                 = 114
+	ReasonDuplicateCommand              = 115 //指令ID小于等于最后处理的指令ID
+	ReasonSeqGap                        = 116 //指令ID不连续
+	ReasonSelfTrade                     = 117 //自成交防护撤单
+	ReasonInvalidTick                   = 118 //价格不是最小变动单位的整数倍
+	ReasonInvalidStep                   = 119 //数量不是最小变动单位的整数倍
+	ReasonSizeTooSmall                  = 120
+	ReasonSizeTooLarge                  = 121
+	ReasonNotionalTooSmall              = 122
+	ReasonNotionalTooLarge              = 123
+	ReasonPriceDecimals                 = 124 //价格小数位超限
+	ReasonSizeDecimals                  = 125 //数量小数位超限
+	ReasonPriceBand                     = 126 //价格超出价格带
+	ReasonCircuitBreaker                = 127 //触发熔断
+	ReasonInvalidStopPrice              = 128 //触发价非法
+	ReasonExpired                       = 129 //订单到期撤销
+	ReasonInvalidExpireTime             = 130
+	ReasonGroupCancelled                = 131 //同组订单成交或撤销后联动撤单
+	ReasonGroupConflict                 = 132 //订单组已结束或角色、用户不匹配
+	ReasonGroupPending                  = 133 //括号单子单未激活
+	ReasonHeartbeatTimeout              = 134 //心跳超时撤单
 )